@@ -0,0 +1,351 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+// Package a2s implements the unauthenticated Source server query protocol, as defined here:
+// https://developer.valvesoftware.com/wiki/Server_queries
+//
+// It complements RCON by reporting a server's name, map, players and rules to anyone, without a password.
+package a2s
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon/internal/discard"
+	"github.com/vibeisveryo/rcon/internal/wire"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request and response types
+const (
+	a2sInfo      = 0x54
+	a2sPlayer    = 0x55
+	a2sRules     = 0x56
+	s2aInfo      = 0x49
+	s2aPlayer    = 0x44
+	s2aRules     = 0x45
+	s2cChallenge = 0x41
+)
+
+// defaultTimeout is how long a query may take when no WithTimeout option is given.
+const defaultTimeout = 4 * time.Second
+
+// maxChallenges is how many challenges a server may answer a query with before the query is abandoned.
+const maxChallenges = 3
+
+// theShip is the app ID of The Ship, whose servers send extra fields in their responses.
+const theShip = 2400
+
+// An Option configures a Client. Options are passed to Dial, and are applied in order, so a later option overrides an
+// earlier one.
+type Option func(*Client)
+
+// WithTimeout sets how long dialing and each query may take; the default is 4 seconds. A zero duration removes the
+// limit, leaving only the contexts passed to Dial and the query methods.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithLogger sets the logger the Client reports to; by default nothing is logged. Every datagram sent and received is
+// logged at debug level. A nil logger restores the default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.log = logger
+		if logger == nil {
+			c.log = discard.Logger
+		}
+	}
+}
+
+// A Client queries a single server. It should be created with Dial, and is safe for concurrent use by multiple
+// goroutines; since responses carry nothing to tell which query they answer, queries are sent one at a time.
+type Client struct {
+	con     net.Conn
+	timeout time.Duration
+	log     *slog.Logger
+
+	// mu serialises queries
+	mu sync.Mutex
+}
+
+// Info is the response to an A2S_INFO query.
+type Info struct {
+	Protocol   int
+	Name       string
+	Map        string
+	Folder     string
+	Game       string
+	AppID      int
+	Players    int
+	MaxPlayers int
+	Bots       int
+	// ServerType is 'd' for a dedicated server, 'l' for a listen server, or 'p' for a SourceTV relay
+	ServerType byte
+	// Environment is 'l' for Linux, 'w' for Windows, or 'm' or 'o' for macOS
+	Environment byte
+	// Private is true if the server has a password
+	Private bool
+	VAC     bool
+	Version string
+
+	// The fields below are only sent by some servers, and are otherwise zero
+	Port          int
+	SteamID       uint64
+	SpectatorPort int
+	SpectatorName string
+	Keywords      string
+	GameID        uint64
+}
+
+// A Player is one entry of the response to an A2S_PLAYER query.
+type Player struct {
+	Index    int
+	Name     string
+	Score    int
+	Duration time.Duration
+}
+
+// Dial prepares to query the server at the given host and port; it returns a non-nil error on illegal argument or on
+// failure to resolve the host. Since UDP is connectionless, nothing is sent to the server until a query is made.
+func Dial(ctx context.Context, host string, port int, opts ...Option) (*Client, error) {
+	// Checks for argument legality
+	if host == "" {
+		return nil, errors.New("cannot have empty hostname")
+	}
+	if port < 1 || port > 65535 {
+		return nil, errors.New("cannot have invalid port; must be between 1 and 65535, inclusive")
+	}
+	c := &Client{timeout: defaultTimeout, log: discard.Logger}
+	for _, opt := range opts {
+		opt(c)
+	}
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	var dialer net.Dialer
+	con, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	c.con = con
+	return c, nil
+}
+
+// Info sends an A2S_INFO query and returns the server's answer. It returns ctx.Err() if ctx is done first.
+func (c *Client) Info(ctx context.Context) (*Info, error) {
+	response, err := c.query(ctx, append([]byte{a2sInfo}, "Source Engine Query\x00"...), s2aInfo)
+	if err != nil {
+		return nil, err
+	}
+	r := wire.NewReader(response)
+	info := &Info{
+		Protocol: int(r.Uint8()),
+		Name:     r.String(),
+		Map:      r.String(),
+		Folder:   r.String(),
+		Game:     r.String(),
+		AppID:    int(r.Uint16()),
+	}
+	info.Players = int(r.Uint8())
+	info.MaxPlayers = int(r.Uint8())
+	info.Bots = int(r.Uint8())
+	info.ServerType = r.Uint8()
+	info.Environment = r.Uint8()
+	info.Private = r.Uint8() != 0
+	info.VAC = r.Uint8() != 0
+	if info.AppID == theShip {
+		// Game mode, witness count and witness duration
+		r.Take(3)
+	}
+	info.Version = r.String()
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+
+	// The extra data flag, if present, tells which of the optional fields follow
+	if len(r.Remaining()) == 0 {
+		return info, nil
+	}
+	edf := r.Uint8()
+	if edf&0x80 != 0 {
+		info.Port = int(r.Uint16())
+	}
+	if edf&0x10 != 0 {
+		info.SteamID = r.Uint64()
+	}
+	if edf&0x40 != 0 {
+		info.SpectatorPort = int(r.Uint16())
+		info.SpectatorName = r.String()
+	}
+	if edf&0x20 != 0 {
+		info.Keywords = r.String()
+	}
+	if edf&0x01 != 0 {
+		info.GameID = r.Uint64()
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return info, nil
+}
+
+// Players sends an A2S_PLAYER query and returns the players on the server. It returns ctx.Err() if ctx is done first.
+func (c *Client) Players(ctx context.Context) ([]Player, error) {
+	response, err := c.query(ctx, []byte{a2sPlayer}, s2aPlayer)
+	if err != nil {
+		return nil, err
+	}
+	r := wire.NewReader(response)
+	n := int(r.Uint8())
+	players := make([]Player, 0, n)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		players = append(players, Player{
+			Index:    int(r.Uint8()),
+			Name:     r.String(),
+			Score:    int(int32(r.Uint32())),
+			Duration: time.Duration(float64(r.Float32()) * float64(time.Second)),
+		})
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return players, nil
+}
+
+// Rules sends an A2S_RULES query and returns the server's rules, which are mostly public console variables, by name.
+// It returns ctx.Err() if ctx is done first.
+func (c *Client) Rules(ctx context.Context) (map[string]string, error) {
+	response, err := c.query(ctx, []byte{a2sRules}, s2aRules)
+	if err != nil {
+		return nil, err
+	}
+	r := wire.NewReader(response)
+	n := int(r.Uint16())
+	rules := make(map[string]string, n)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		name := r.String()
+		rules[name] = r.String()
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return rules, nil
+}
+
+// Close closes the underlying socket. Queries in flight on other goroutines return with an error.
+func (c *Client) Close() error {
+	return c.con.Close()
+}
+
+// query sends a request and returns the payload of the response of the expected type, following the type byte. If the
+// server answers with a challenge, the request is sent again with the challenge appended; A2S_PLAYER and A2S_RULES
+// requests always carry a challenge, which is -1 until the server hands one out.
+func (c *Client) query(ctx context.Context, request []byte, responseType byte) ([]byte, error) {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge := []byte(nil)
+	if request[0] != a2sInfo {
+		challenge = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+	}
+	for i := 0; ; i++ {
+		var response []byte
+		err := wire.Interruptible(ctx, c.con.SetDeadline, func() (err error) {
+			err = c.send(append(request[:len(request):len(request)], challenge...))
+			if err != nil {
+				return err
+			}
+			response, err = c.receive()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(response) == 0 {
+			return nil, wire.ErrTruncated
+		}
+		switch response[0] {
+		case responseType:
+			return response[1:], nil
+		case s2cChallenge:
+			if len(response) < 5 {
+				return nil, wire.ErrTruncated
+			}
+			if i == maxChallenges {
+				return nil, errors.New("server kept answering with challenges")
+			}
+			challenge = response[1:5]
+		default:
+			return nil, fmt.Errorf("unexpected response type %#x", response[0])
+		}
+	}
+}
+
+// send writes a single-packet request.
+func (c *Client) send(request []byte) error {
+	data := binary.LittleEndian.AppendUint32(nil, singlePacket)
+	data = append(data, request...)
+	c.log.Debug("send A2S packet", "size", len(data), "type", request[0])
+	_, err := c.con.Write(data)
+	return err
+}
+
+// receive reads datagrams until a whole response has arrived, and returns its payload without the packet header.
+// Datagrams which cannot be parsed are ignored.
+func (c *Client) receive() ([]byte, error) {
+	splits := make(map[uint32]*split)
+	buf := make([]byte, 65536)
+	for {
+		n, err := c.con.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		c.log.Debug("receive A2S packet", "size", n)
+		if n < 4 {
+			continue
+		}
+		data := buf[:n]
+		switch binary.LittleEndian.Uint32(data) {
+		case singlePacket:
+			return append([]byte(nil), data[4:]...), nil
+		case splitPacket:
+			payload, err := reassemble(splits, data[4:])
+			if err != nil {
+				return nil, err
+			}
+			// The reassembled payload has a header of its own
+			if len(payload) >= 4 && binary.LittleEndian.Uint32(payload) == singlePacket {
+				return payload[4:], nil
+			}
+		}
+	}
+}
+
+// queryContext derives the context bounding a single query from ctx.
+func (c *Client) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package a2s
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testChallenge is the challenge handed out by serveA2S.
+var testChallenge = []byte{0x01, 0x02, 0x03, 0x04}
+
+// compressedRules is an S2A_RULES response with the rules mp_timelimit 30 and sv_gravity 800, compressed with bzip2.
+var compressedRules = []byte("\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x6a\xb8\x69\x40\x00\x00\x13\x4f\x80\xd0\x00" +
+	"\x48\x40\x02\x00\x00\x00\xa2\xa6\x5d\x20\x00\x00\xa0\x00\x22\x11\xa0\xd0\x03\xca\x14\xc2\x69\xa0\x34\xc4\xe8\x32" +
+	"\x9a\x3d\x62\xee\xa7\xc0\x6b\x41\xa9\xd0\x96\x90\x8b\x3c\x09\xb8\x5a\x7c\x5d\xc9\x14\xe1\x42\x41\xaa\xe1\xa5\x00")
+
+const (
+	compressedRulesSize = 38
+	compressedRulesCRC  = 0x4108c657
+)
+
+// packetWriter builds little-endian test packets.
+type packetWriter struct {
+	bytes.Buffer
+}
+
+func (w *packetWriter) put(values ...any) *packetWriter {
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			w.WriteString(s)
+			w.WriteByte(0)
+			continue
+		}
+		_ = binary.Write(w, binary.LittleEndian, v)
+	}
+	return w
+}
+
+// serveA2S answers queries on pc until it is closed. Every query must carry testChallenge; the info response fits a
+// single packet, the player response is split in two parts sent out of order, and the rules response is compressed and
+// split in two parts.
+func serveA2S(pc net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply := func(data []byte) {
+			_, _ = pc.WriteTo(data, addr)
+		}
+		request := buf[4:n]
+		if !bytes.HasSuffix(request, testChallenge) {
+			reply(new(packetWriter).put(uint32(singlePacket), uint8(s2cChallenge), testChallenge).Bytes())
+			continue
+		}
+		split := func(id uint32, payload []byte) [][]byte {
+			half := len(payload) / 2
+			return [][]byte{
+				new(packetWriter).put(uint32(splitPacket), id, uint8(2), uint8(0), uint16(1248), payload[:half]).Bytes(),
+				new(packetWriter).put(uint32(splitPacket), id, uint8(2), uint8(1), uint16(1248), payload[half:]).Bytes(),
+			}
+		}
+		switch request[0] {
+		case a2sInfo:
+			reply(new(packetWriter).put(uint32(singlePacket), uint8(s2aInfo), uint8(17), "Test Server", "cp_badlands",
+				"tf", "Team Fortress", uint16(440), uint8(3), uint8(24), uint8(1), uint8('d'), uint8('l'), uint8(1),
+				uint8(1), "8622567", uint8(0x80|0x20), uint16(27015), "payload,cp").Bytes())
+		case a2sPlayer:
+			payload := new(packetWriter).put(uint32(singlePacket), uint8(s2aPlayer), uint8(2),
+				uint8(0), "alice", int32(12), float32(90),
+				uint8(1), "bob", int32(-1), float32(1.5)).Bytes()
+			parts := split(7, payload)
+			reply(parts[1])
+			reply(parts[0])
+		case a2sRules:
+			id := uint32(8 | compressedFlag)
+			half := len(compressedRules) / 2
+			reply(new(packetWriter).put(uint32(splitPacket), id, uint8(2), uint8(0), uint16(1248),
+				uint32(compressedRulesSize), uint32(compressedRulesCRC), compressedRules[:half]).Bytes())
+			reply(new(packetWriter).put(uint32(splitPacket), id, uint8(2), uint8(1), uint16(1248),
+				compressedRules[half:]).Bytes())
+		}
+	}
+}
+
+// newTestClient starts serveA2S on a loopback port and returns a client querying it.
+func newTestClient(t *testing.T) *Client {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Encountered error while listening: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = pc.Close()
+	})
+	go serveA2S(pc)
+	c, err := Dial(context.Background(), "127.0.0.1", pc.LocalAddr().(*net.UDPAddr).Port, WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Encountered error while dialing: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestInfo(t *testing.T) {
+	c := newTestClient(t)
+	got, err := c.Info(context.Background())
+	if err != nil {
+		t.Fatalf("Encountered error while querying info: %v", err)
+	}
+	want := &Info{Protocol: 17, Name: "Test Server", Map: "cp_badlands", Folder: "tf", Game: "Team Fortress",
+		AppID: 440, Players: 3, MaxPlayers: 24, Bots: 1, ServerType: 'd', Environment: 'l', Private: true, VAC: true,
+		Version: "8622567", Port: 27015, Keywords: "payload,cp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected info %+v, got %+v", want, got)
+	}
+}
+
+func TestPlayers(t *testing.T) {
+	c := newTestClient(t)
+	got, err := c.Players(context.Background())
+	if err != nil {
+		t.Fatalf("Encountered error while querying players: %v", err)
+	}
+	want := []Player{
+		{Index: 0, Name: "alice", Score: 12, Duration: 90 * time.Second},
+		{Index: 1, Name: "bob", Score: -1, Duration: 1500 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected players %+v, got %+v", want, got)
+	}
+}
+
+func TestRules(t *testing.T) {
+	c := newTestClient(t)
+	got, err := c.Rules(context.Background())
+	if err != nil {
+		t.Fatalf("Encountered error while querying rules: %v", err)
+	}
+	want := map[string]string{"mp_timelimit": "30", "sv_gravity": "800"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected rules %v, got %v", want, got)
+	}
+}
+
+func TestReassembleCompressedSize(t *testing.T) {
+	cases := []struct {
+		size    uint32
+		wantErr bool
+	}{
+		{compressedRulesSize, false},
+		{maxPayloadSize + 1, true},
+		{math.MaxUint32, true},
+	}
+	for _, c := range cases {
+		splits := make(map[uint32]*split)
+		data := new(packetWriter).put(uint32(8|compressedFlag), uint8(1), uint8(0), uint16(1248), c.size,
+			uint32(compressedRulesCRC), compressedRules).Bytes()
+		_, err := reassemble(splits, data)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Size %v, expected error %v, got %v", c.size, c.wantErr, err)
+		}
+		if c.wantErr && len(splits) != 0 {
+			t.Errorf("Size %v, expected nothing to be kept, got %v splits", c.size, len(splits))
+		}
+	}
+}
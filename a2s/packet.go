@@ -0,0 +1,109 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package a2s
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon/internal/wire"
+	"hash/crc32"
+	"io"
+)
+
+// Packet headers, as defined here: https://developer.valvesoftware.com/wiki/Server_queries#Protocol
+const (
+	singlePacket = 0xFFFFFFFF
+	splitPacket  = 0xFFFFFFFE
+)
+
+// compressedFlag is set in the id of a split packet whose payload is bzip2-compressed.
+const compressedFlag = 0x80000000
+
+const (
+	// maxPartSize is the largest part a server splits a response into, payload and header included
+	maxPartSize = 1400
+	// maxPayloadSize is the largest decompressed payload accepted: as much as the greatest number of parts, 255, could
+	// carry uncompressed. Larger sizes are rejected before decompressing, so that a few datagrams claiming a huge size
+	// cannot make the client decompress gigabytes.
+	maxPayloadSize = 255 * maxPartSize
+)
+
+// A split is the set of parts of a split packet received so far.
+type split struct {
+	parts    [][]byte
+	received int
+	// size and crc describe the decompressed payload of a compressed packet
+	size uint32
+	crc  uint32
+}
+
+// reassemble records a part of a split packet in splits, which is keyed by packet id; data excludes the split packet
+// header. Once every part of a packet has arrived, it returns the whole payload, decompressed and checked if need be;
+// otherwise it returns nil.
+//
+// A part has a 4-byte packet id, a byte holding the number of parts, a byte holding the index of the part, and the
+// 2-byte maximum part size. The first part of a compressed packet then has the 4-byte decompressed size and CRC32
+// checksum of the payload.
+func reassemble(splits map[uint32]*split, data []byte) ([]byte, error) {
+	r := wire.NewReader(data)
+	id := r.Uint32()
+	total, index := int(r.Uint8()), int(r.Uint8())
+	r.Uint16()
+	var size, crc uint32
+	if id&compressedFlag != 0 && index == 0 {
+		size, crc = r.Uint32(), r.Uint32()
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	if total == 0 || index >= total {
+		return nil, fmt.Errorf("invalid data - part %v of %v", index, total)
+	}
+	if size > maxPayloadSize {
+		return nil, fmt.Errorf("invalid data - decompressed size %v exceeds %v bytes", size, maxPayloadSize)
+	}
+	s := splits[id]
+	if s == nil {
+		s = &split{parts: make([][]byte, total)}
+		splits[id] = s
+	}
+	if index >= len(s.parts) || s.parts[index] != nil {
+		return nil, nil
+	}
+	if index == 0 {
+		s.size, s.crc = size, crc
+	}
+	s.parts[index] = append([]byte(nil), r.Remaining()...)
+	s.received++
+	if s.received < len(s.parts) {
+		return nil, nil
+	}
+	delete(splits, id)
+	payload := bytes.Join(s.parts, nil)
+	if id&compressedFlag == 0 {
+		return payload, nil
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(bzip2.NewReader(bytes.NewReader(payload)), int64(s.size)+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data - %w", err)
+	}
+	if uint32(len(decompressed)) != s.size || crc32.ChecksumIEEE(decompressed) != s.crc {
+		return nil, errors.New("invalid data - decompressed payload does not match its size and checksum")
+	}
+	return decompressed, nil
+}
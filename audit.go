@@ -0,0 +1,68 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"time"
+)
+
+// An AuditRecord describes a command sent to the server, for keeping a record of who ran what.
+type AuditRecord struct {
+	// Time is when the command was sent
+	Time time.Time
+	// Command is the command as passed to SendCommand or SendCommandContext
+	Command string
+	// Response is the output of the command, or as much of it as was received if it failed
+	Response string
+	// Duration is how long the command took, including any reconnection and retry
+	Duration time.Duration
+	// Err is the error the command returned, or nil if it succeeded
+	Err error
+}
+
+// An AuditHook is called once for every command sent on a connection, successful or not, after the command returns.
+// ctx is the context the command was sent with, which may carry values identifying on whose behalf it was sent. The
+// hook is called synchronously from the goroutine which sent the command, so it should return promptly; it may be
+// called concurrently if commands are.
+type AuditHook func(ctx context.Context, record AuditRecord)
+
+// WithAuditHook has every command sent on the connection reported to hook. It applies to the connection types of
+// every protocol. Commands sent internally, such as the pings of the Source RCON protocol, are not reported.
+func WithAuditHook(hook AuditHook) Option {
+	return func(o *options) {
+		o.audit = hook
+	}
+}
+
+// auditCommand sends cmd with send, reporting it to the audit hook if there is one.
+func (o options) auditCommand(ctx context.Context, cmd string,
+	send func(ctx context.Context, cmd string) (string, error)) (string, error) {
+	if o.audit == nil {
+		return send(ctx, cmd)
+	}
+	start := time.Now()
+	resp, err := send(ctx, cmd)
+	o.audit(ctx, AuditRecord{
+		Time:     start,
+		Command:  cmd,
+		Response: resp,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return resp, err
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type auditUserKey struct{}
+
+func TestAuditHook(t *testing.T) {
+	var mu sync.Mutex
+	var records []AuditRecord
+	var users []any
+	hook := func(ctx context.Context, record AuditRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, record)
+		users = append(users, ctx.Value(auditUserKey{}))
+	}
+	conn := newEchoConnection(t, WithAuditHook(hook), WithCommandTimeout(50*time.Millisecond))
+	defer conn.Close()
+
+	cases := []struct {
+		cmd      string
+		user     string
+		response string
+		wantErr  bool
+	}{
+		{"echo first", "alice", "first", false},
+		{"echo second", "bob", "second", false},
+		{"hang", "alice", "", true},
+	}
+	for _, c := range cases {
+		ctx := context.WithValue(context.Background(), auditUserKey{}, c.user)
+		before := time.Now()
+		_, _ = conn.SendCommandContext(ctx, c.cmd)
+
+		mu.Lock()
+		if len(records) == 0 {
+			mu.Unlock()
+			t.Fatalf("Command %q was not reported to the audit hook", c.cmd)
+		}
+		record, user := records[len(records)-1], users[len(users)-1]
+		records, users = nil, nil
+		mu.Unlock()
+		if record.Command != c.cmd {
+			t.Errorf("Command %q, expected %q, got %q", c.cmd, c.cmd, record.Command)
+		}
+		if record.Response != c.response {
+			t.Errorf("Command %q, expected response %q, got %q", c.cmd, c.response, record.Response)
+		}
+		if (record.Err != nil) != c.wantErr {
+			t.Errorf("Command %q, expected error %v, got %v", c.cmd, c.wantErr, record.Err)
+		}
+		if user != c.user {
+			t.Errorf("Command %q, expected user %v, got %v", c.cmd, c.user, user)
+		}
+		if record.Time.Before(before) || record.Duration <= 0 {
+			t.Errorf("Command %q, expected timing after %v, got %v taking %v", c.cmd, before, record.Time,
+				record.Duration)
+		}
+	}
+}
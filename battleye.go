@@ -0,0 +1,410 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon/internal/wire"
+	"hash/crc32"
+	"net"
+	"sync"
+	"time"
+)
+
+// BattlEye RCon packet types, as defined here: https://www.battleye.com/downloads/BERConProtocol.txt
+const (
+	battlEyeLogin   = 0x00
+	battlEyeCommand = 0x01
+	battlEyeMessage = 0x02
+)
+
+// battlEyeKeepAliveInterval is how long the connection may go without sending anything before a keep-alive is sent;
+// BattlEye servers drop clients which have been silent for 45 seconds. It is a variable so that tests can shorten it.
+var battlEyeKeepAliveInterval = 30 * time.Second
+
+const (
+	// battlEyeKeepAliveTimeout is how long the server has to answer a keep-alive before the connection is failed
+	battlEyeKeepAliveTimeout = 10 * time.Second
+	// battlEyeMessageBuffer is how many server messages are held for the reader of Messages before more are dropped
+	battlEyeMessageBuffer = 64
+)
+
+// A BattlEyeConnection connects to a remote server using the BattlEye RCon protocol, as used by Arma and DayZ servers,
+// documented in the following link. The protocol runs over UDP and is unrelated to Source RCON, but commands are sent
+// in the same way as with an RCONConnection. In addition, the server pushes messages such as chat and player
+// connections, which are acknowledged automatically and made available from Messages.
+// https://www.battleye.com/downloads/BERConProtocol.txt
+//
+// A BattlEyeConnection should be created with NewBattlEyeConnection or NewBattlEyeConnectionContext, and is safe for
+// concurrent use by multiple goroutines. Keep-alive packets are sent on its behalf while it is otherwise idle.
+type BattlEyeConnection struct {
+	con  net.Conn
+	opts options
+
+	// writeMu serialises packet writes
+	writeMu sync.Mutex
+
+	// mu guards the fields below
+	mu      sync.Mutex
+	seq     byte
+	pending map[byte]*battlEyeCall
+	// lastMessage is the sequence number of the last server message delivered, or -1 before the first
+	lastMessage int
+	lastSent    time.Time
+	err         error
+
+	// failed is closed, after err is set, once the connection can no longer be used
+	failed        chan struct{}
+	messages      chan string
+	readerDone    chan struct{}
+	keepAliveDone chan struct{}
+}
+
+// A battlEyeCall collects the response to a single command, which may arrive in several parts.
+type battlEyeCall struct {
+	parts    [][]byte
+	received int
+	body     string
+	// done is closed once the whole response has arrived
+	done chan struct{}
+}
+
+// NewBattlEyeConnection logs in to the BattlEye RCon server at the given host and port with password. It returns an
+// AuthenticationFailure if the password is rejected.
+func NewBattlEyeConnection(host string, port int, password string, opts ...Option) (*BattlEyeConnection, error) {
+	return NewBattlEyeConnectionContext(context.Background(), host, port, password, opts...)
+}
+
+// NewBattlEyeConnectionContext is like NewBattlEyeConnection, but abandons logging in once ctx is done, in which case
+// it returns ctx.Err().
+func NewBattlEyeConnectionContext(ctx context.Context, host string, port int, password string,
+	opts ...Option) (*BattlEyeConnection, error) {
+	o, err := serverOptions(host, port, opts)
+	if err != nil {
+		return nil, err
+	}
+	con, err := o.dialServer(ctx, "udp", host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	loginCtx, cancel := o.commandContext(ctx)
+	defer cancel()
+	err = wire.Interruptible(loginCtx, con.SetDeadline, func() error {
+		return logInBattlEye(con, password)
+	})
+	if err != nil {
+		_ = con.Close()
+		return nil, err
+	}
+
+	conn := &BattlEyeConnection{
+		con:           con,
+		opts:          o,
+		pending:       make(map[byte]*battlEyeCall),
+		lastMessage:   -1,
+		lastSent:      time.Now(),
+		failed:        make(chan struct{}),
+		messages:      make(chan string, battlEyeMessageBuffer),
+		readerDone:    make(chan struct{}),
+		keepAliveDone: make(chan struct{}),
+	}
+	go conn.readLoop()
+	go conn.keepAliveLoop()
+	return conn, nil
+}
+
+// logInBattlEye sends the login packet and waits for the server's verdict on the password.
+func logInBattlEye(con net.Conn, password string) error {
+	data := encodeBattlEye(battlEyeLogin, []byte(password))
+	_, err := con.Write(data)
+	clear(data)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 65536)
+	for {
+		n, err := con.Read(buf)
+		if err != nil {
+			return err
+		}
+		packetType, payload, err := decodeBattlEye(buf[:n])
+		if err != nil || packetType != battlEyeLogin {
+			continue
+		}
+		if len(payload) == 1 && payload[0] == 0x01 {
+			return nil
+		}
+		return new(AuthenticationFailure)
+	}
+}
+
+// SendCommand takes a command string, sends it to the server, and returns the output as a string. It returns a non-nil
+// error on send or read failure.
+func (conn *BattlEyeConnection) SendCommand(cmd string) (string, error) {
+	return conn.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext is like SendCommand, but gives up waiting on the server once ctx is done and returns ctx.Err().
+// The connection remains usable; a response arriving afterwards is discarded.
+func (conn *BattlEyeConnection) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	return conn.opts.auditCommand(ctx, cmd, conn.sendCommand)
+}
+
+// sendCommand implements SendCommandContext.
+func (conn *BattlEyeConnection) sendCommand(ctx context.Context, cmd string) (string, error) {
+	ctx, cancel := conn.opts.commandContext(ctx)
+	defer cancel()
+
+	seq, c, err := conn.startCall()
+	if err != nil {
+		return "", err
+	}
+	defer conn.endCall(seq, c)
+
+	err = conn.send(ctx, battlEyeCommand, append([]byte{seq}, cmd...))
+	if err != nil {
+		return "", err
+	}
+	select {
+	case <-c.done:
+		return c.body, nil
+	case <-conn.failed:
+		return "", conn.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Messages returns a channel delivering the messages pushed by the server, such as chat and player connections. The
+// channel is closed when the connection fails or is closed. Messages are buffered, but dropped if the buffer is full,
+// so the channel should be drained promptly by anyone interested in them.
+func (conn *BattlEyeConnection) Messages() <-chan string {
+	return conn.messages
+}
+
+// Close logs out by closing the connection. Commands in flight on other goroutines return with an error.
+func (conn *BattlEyeConnection) Close() {
+	conn.fail(errClosed)
+	<-conn.readerDone
+	<-conn.keepAliveDone
+}
+
+// startCall allocates the next sequence number and registers a call to collect the response sent back with it.
+// Callers must defer endCall with the returned values.
+func (conn *BattlEyeConnection) startCall() (byte, *battlEyeCall, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.err != nil {
+		return 0, nil, conn.err
+	}
+	// Sequence numbers are a single byte, so at most 256 commands can be in flight
+	for i := 0; i < 256; i++ {
+		seq := conn.seq
+		conn.seq++
+		if _, ok := conn.pending[seq]; !ok {
+			c := &battlEyeCall{done: make(chan struct{})}
+			conn.pending[seq] = c
+			return seq, c, nil
+		}
+	}
+	return 0, nil, errors.New("too many commands in flight")
+}
+
+// endCall unregisters a call started with startCall, if it is still waiting on a response.
+func (conn *BattlEyeConnection) endCall(seq byte, c *battlEyeCall) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.pending[seq] == c {
+		delete(conn.pending, seq)
+	}
+}
+
+// send writes a packet of the given type to the server, failing the connection if the write does not succeed.
+func (conn *BattlEyeConnection) send(ctx context.Context, packetType byte, payload []byte) error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	select {
+	case <-conn.failed:
+		return conn.err
+	default:
+	}
+	data := encodeBattlEye(packetType, payload)
+	body := string(payload)
+	if packetType == battlEyeLogin {
+		body = redacted
+	}
+	conn.opts.logger.Debug("send BattlEye packet", "type", packetType, "size", len(data), "body", body)
+	err := wire.Interruptible(ctx, conn.con.SetWriteDeadline, func() error {
+		_, err := conn.con.Write(data)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			conn.fail(fmt.Errorf("connection failed on write: %w", err))
+		}
+		return err
+	}
+	conn.mu.Lock()
+	conn.lastSent = time.Now()
+	conn.mu.Unlock()
+	return nil
+}
+
+// readLoop receives datagrams until the connection fails, completing command responses and acknowledging and
+// delivering server messages. Datagrams which fail their checksum are ignored.
+func (conn *BattlEyeConnection) readLoop() {
+	defer close(conn.readerDone)
+	defer close(conn.messages)
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.con.Read(buf)
+		if err != nil {
+			conn.fail(err)
+			return
+		}
+		packetType, payload, err := decodeBattlEye(buf[:n])
+		if err != nil || len(payload) < 1 {
+			conn.opts.logger.Debug("discard invalid BattlEye packet", "size", n, "error", err)
+			continue
+		}
+		conn.opts.logger.Debug("receive BattlEye packet", "type", packetType, "size", n, "body", string(payload))
+		seq, body := payload[0], payload[1:]
+		switch packetType {
+		case battlEyeCommand:
+			conn.receiveResponse(seq, body)
+		case battlEyeMessage:
+			// Messages must be acknowledged, or the server resends them and eventually drops the client
+			_ = conn.send(context.Background(), battlEyeMessage, []byte{seq})
+			conn.mu.Lock()
+			duplicate := conn.lastMessage == int(seq)
+			conn.lastMessage = int(seq)
+			conn.mu.Unlock()
+			if duplicate {
+				continue
+			}
+			select {
+			case conn.messages <- string(body):
+			default:
+				conn.opts.logger.Warn("drop BattlEye message", "seq", seq, "body", string(body))
+			}
+		}
+	}
+}
+
+// receiveResponse adds a response packet to the call waiting on seq, completing the call once every part of a
+// multiple-packet response has arrived.
+func (conn *BattlEyeConnection) receiveResponse(seq byte, body []byte) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	c := conn.pending[seq]
+	if c == nil {
+		return
+	}
+	// A multiple-packet response has a header of 0x00, the number of packets, and the index of this packet
+	if len(body) >= 3 && body[0] == 0x00 {
+		total, index := int(body[1]), int(body[2])
+		if total == 0 || index >= total {
+			return
+		}
+		if c.parts == nil {
+			c.parts = make([][]byte, total)
+		}
+		if index >= len(c.parts) || c.parts[index] != nil {
+			return
+		}
+		c.parts[index] = append([]byte(nil), body[3:]...)
+		c.received++
+		if c.received < len(c.parts) {
+			return
+		}
+		c.body = string(bytes.Join(c.parts, nil))
+	} else {
+		c.body = string(body)
+	}
+	delete(conn.pending, seq)
+	close(c.done)
+}
+
+// keepAliveLoop sends an empty command whenever the connection has been idle for too long, failing the connection if
+// the server does not answer.
+func (conn *BattlEyeConnection) keepAliveLoop() {
+	defer close(conn.keepAliveDone)
+	ticker := time.NewTicker(battlEyeKeepAliveInterval / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-conn.failed:
+			return
+		}
+		conn.mu.Lock()
+		idle := time.Since(conn.lastSent)
+		conn.mu.Unlock()
+		if idle < battlEyeKeepAliveInterval {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), battlEyeKeepAliveTimeout)
+		// Keep-alives are not commands of the user's, so they are kept from the audit hook
+		_, err := conn.sendCommand(ctx, "")
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			conn.fail(errors.New("server stopped answering keep-alives"))
+		}
+	}
+}
+
+// fail marks the connection as unusable with the given error, if it has not failed already, and closes the socket so
+// that the reader goroutine exits.
+func (conn *BattlEyeConnection) fail(err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.err != nil {
+		return
+	}
+	conn.err = err
+	close(conn.failed)
+	_ = conn.con.Close()
+}
+
+// encodeBattlEye builds a BattlEye packet of the given type: the "BE" header, a CRC32 checksum of everything after it,
+// then 0xFF, the type and the payload.
+func encodeBattlEye(packetType byte, payload []byte) []byte {
+	data := make([]byte, 8+len(payload))
+	data[0], data[1] = 'B', 'E'
+	data[6], data[7] = 0xFF, packetType
+	copy(data[8:], payload)
+	binary.LittleEndian.PutUint32(data[2:6], crc32.ChecksumIEEE(data[6:]))
+	return data
+}
+
+// decodeBattlEye checks the header and checksum of a BattlEye packet, and returns its type and payload.
+func decodeBattlEye(data []byte) (byte, []byte, error) {
+	if len(data) < 8 {
+		return 0, nil, errors.New("invalid data - too short")
+	}
+	if data[0] != 'B' || data[1] != 'E' || data[6] != 0xFF {
+		return 0, nil, errors.New("invalid data - bad header")
+	}
+	if binary.LittleEndian.Uint32(data[2:6]) != crc32.ChecksumIEEE(data[6:]) {
+		return 0, nil, errors.New("invalid data - checksum mismatch")
+	}
+	return data[7], data[8:], nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveBattlEye answers a single BattlEye client on pc until it is closed. After login it pushes the message "hello"
+// twice with the same sequence number, as a server does when the first acknowledgement goes missing; it answers the
+// command "parts" with a response in three out-of-order parts, and echoes any other command.
+func serveBattlEye(pc net.PacketConn, acks chan<- byte) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packetType, payload, err := decodeBattlEye(buf[:n])
+		if err != nil {
+			continue
+		}
+		reply := func(packetType byte, payload ...byte) {
+			_, _ = pc.WriteTo(encodeBattlEye(packetType, payload), addr)
+		}
+		switch packetType {
+		case battlEyeLogin:
+			if string(payload) != testPassword {
+				reply(battlEyeLogin, 0x00)
+				continue
+			}
+			reply(battlEyeLogin, 0x01)
+			reply(battlEyeMessage, append([]byte{0}, "hello"...)...)
+			reply(battlEyeMessage, append([]byte{0}, "hello"...)...)
+		case battlEyeCommand:
+			seq, cmd := payload[0], string(payload[1:])
+			if cmd != "parts" {
+				reply(battlEyeCommand, append([]byte{seq}, cmd...)...)
+				continue
+			}
+			reply(battlEyeCommand, append([]byte{seq, 0x00, 3, 2}, "baz"...)...)
+			reply(battlEyeCommand, append([]byte{seq, 0x00, 3, 0}, "foo"...)...)
+			reply(battlEyeCommand, append([]byte{seq, 0x00, 3, 1}, "bar"...)...)
+		case battlEyeMessage:
+			acks <- payload[0]
+		}
+	}
+}
+
+// newBattlEyeServer starts serveBattlEye on a loopback port, returning the port and a channel of acknowledgements.
+func newBattlEyeServer(t *testing.T) (int, chan byte) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Encountered error while listening: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = pc.Close()
+	})
+	acks := make(chan byte, 16)
+	go serveBattlEye(pc, acks)
+	return pc.LocalAddr().(*net.UDPAddr).Port, acks
+}
+
+func TestBattlEyeConnection(t *testing.T) {
+	port, acks := newBattlEyeServer(t)
+	conn, err := NewBattlEyeConnection("127.0.0.1", port, testPassword, WithCommandTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"players", "players"},
+		{"parts", "foobarbaz"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		got, err := conn.SendCommand(c.in)
+		if err != nil {
+			t.Errorf("Encountered error while sending command %q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Command %q, expected response %q, got %q", c.in, c.want, got)
+		}
+	}
+
+	// Both copies of the message are acknowledged, but it is delivered once
+	select {
+	case msg := <-conn.Messages():
+		if msg != "hello" {
+			t.Errorf("Expected message %q, got %q", "hello", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for server message")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-acks:
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for acknowledgement %v", i)
+		}
+	}
+	select {
+	case msg := <-conn.Messages():
+		t.Errorf("Expected duplicate message to be dropped, got %q", msg)
+	default:
+	}
+}
+
+func TestBattlEyeWrongPassword(t *testing.T) {
+	port, _ := newBattlEyeServer(t)
+	_, err := NewBattlEyeConnection("127.0.0.1", port, "wrong", WithCommandTimeout(time.Second))
+	if _, ok := err.(*AuthenticationFailure); !ok {
+		t.Errorf("Expected authentication failure, got %v", err)
+	}
+}
+
+func TestBattlEyeCodec(t *testing.T) {
+	data := encodeBattlEye(battlEyeLogin, []byte("password"))
+	want := []byte{'B', 'E', 0xde, 0x26, 0x2d, 0x52, 0xFF, 0x00, 'p', 'a', 's', 's', 'w', 'o', 'r', 'd'}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("Expected login packet bytes %v, got %v", want, data)
+	}
+	packetType, payload, err := decodeBattlEye(data)
+	if err != nil {
+		t.Fatalf("Encountered error while decoding packet %v: %v", data, err)
+	}
+	if packetType != battlEyeLogin || !reflect.DeepEqual(payload, []byte("password")) {
+		t.Errorf("Expected login packet with payload %q, got type %v payload %q", "password", packetType, payload)
+	}
+	for i := 2; i < len(data); i++ {
+		corrupt := append([]byte(nil), data...)
+		corrupt[i] ^= 0x01
+		if _, _, err := decodeBattlEye(corrupt); err == nil {
+			t.Errorf("Expected error decoding packet corrupted at byte %v", i)
+		}
+	}
+}
+
+func TestBattlEyeKeepAliveNotAudited(t *testing.T) {
+	interval := battlEyeKeepAliveInterval
+	battlEyeKeepAliveInterval = 30 * time.Millisecond
+	t.Cleanup(func() {
+		battlEyeKeepAliveInterval = interval
+	})
+	var mu sync.Mutex
+	var audited []string
+	hook := func(ctx context.Context, record AuditRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		audited = append(audited, record.Command)
+	}
+	port, _ := newBattlEyeServer(t)
+	conn, err := NewBattlEyeConnection("127.0.0.1", port, testPassword, WithCommandTimeout(time.Second),
+		WithAuditHook(hook))
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.SendCommand("players"); err != nil {
+		t.Fatalf("Encountered error while sending command: %v", err)
+	}
+	// Idle for long enough that several keep-alives are sent
+	time.Sleep(10 * battlEyeKeepAliveInterval)
+	conn.mu.Lock()
+	idle := time.Since(conn.lastSent)
+	conn.mu.Unlock()
+	if idle >= 5*battlEyeKeepAliveInterval {
+		t.Fatalf("Expected keep-alives to be sent, but connection has been idle for %v", idle)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"players"}
+	if !reflect.DeepEqual(audited, want) {
+		t.Errorf("Expected audited commands %q, got %q", want, audited)
+	}
+}
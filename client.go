@@ -17,213 +17,380 @@ https://www.gnu.org/licenses.
 package rcon
 
 import (
+	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/vibeisveryo/rcon/internal/discard"
+	"github.com/vibeisveryo/rcon/internal/wire"
 	"io"
+	"log/slog"
 	"net"
-	"os"
-	"strconv"
+	"sync"
 	"time"
 )
 
-var Debug = false
+const (
+	// minPacketSize is the smallest valid size field: that of a packet with an empty body
+	minPacketSize = 10
+	// defaultMaxPacketSize is the largest size field accepted from servers which split responses into packets, unless
+	// WithMaxPacketSize says otherwise; that of a packet with a body of 4096 bytes, the most such servers send
+	defaultMaxPacketSize = 4096 + minPacketSize
+	// singleResponseMaxPacketSize is the largest size field accepted from servers which send each response in a single
+	// packet, however long, unless WithMaxPacketSize says otherwise
+	singleResponseMaxPacketSize = 1 << 24
+)
+
+// maxRetainedBuffer is the largest buffer kept for reuse once a packet has been sent or received. Larger packets, which
+// only some dialects send, get a buffer of their own, so that one long response does not pin its memory for good.
+const maxRetainedBuffer = 64 << 10
+
+// writeBuffers holds buffers for encoding outgoing packets, so that sending does not allocate.
+var writeBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+// A PacketType is the type field of a Packet, telling what the packet is for.
+type PacketType int32
 
+// The packet types of the Source RCON protocol. SERVERDATA_AUTH_RESPONSE and SERVERDATA_EXECCOMMAND share a value;
+// which is meant follows from whether the server or the client sends the packet.
+//
 //goland:noinspection SpellCheckingInspection
 const (
-	serverdataAuth          = 3
-	serverdataAuthResponse  = 2
-	serverdataExeccommand   = 2
-	serverdataResponseValue = 0
+	ServerdataAuth          PacketType = 3
+	ServerdataAuthResponse  PacketType = 2
+	ServerdataExeccommand   PacketType = 2
+	ServerdataResponseValue PacketType = 0
 )
 
-// RCON TCP Packet as defined here: https://developer.valvesoftware.com/wiki/Source_RCON_Protocol
-type packet struct {
+// A Packet is a single RCON packet, as defined here: https://developer.valvesoftware.com/wiki/Source_RCON_Protocol
+//
+// RCONConnection takes care of packets itself; they are exported for building proxies, test servers and custom
+// handshakes. See Conn for sending and receiving them over a connection.
+type Packet struct {
 	// ID for the packet; will be reflected in the server's response(s)
-	packetId int
-	// The packet type; serverdataAuth, serverdataAuthResponse, serverdataExeccommand, or serverdataResponseValue
-	packetType int
+	ID int
+	// The packet type; ServerdataAuth, ServerdataAuthResponse, ServerdataExeccommand, or ServerdataResponseValue
+	Type PacketType
 	// String body for the packet; does not need to be null terminated, the methods will do this for you
-	packetBody string
+	Body string
+}
+
+// Encode writes the packet to w, including its size field, in a single write.
+func (p Packet) Encode(w io.Writer) error {
+	bufp := writeBuffers.Get().(*[]byte)
+	buf := p.appendPacket((*bufp)[:0])
+	defer releaseWriteBuffer(bufp, buf, p.Type == ServerdataAuth)
+	_, err := w.Write(buf)
+	return err
+}
+
+// Decode reads a packet from r into p. A packet larger than a body of 4096 bytes allows is rejected with a
+// *PacketSizeError, as is one whose size field is too small to be valid; a Conn with WithMaxPacketSize accepts longer
+// packets. If r is not buffered, Decode makes two reads per packet.
+func (p *Packet) Decode(r io.Reader) error {
+	decoded, _, err := readPacket(r, nil, defaultMaxPacketSize)
+	if err != nil {
+		return err
+	}
+	*p = decoded
+	return nil
 }
 
 // size calculates the packet's size based on how long it should be given its fields; for specification purposes
-func (p packet) size() int {
-	var packetSize = 0                  // Do not count the size field
-	packetSize += 4                     // 4 bytes for ID field
-	packetSize += 4                     // 4 bytes for Type field
-	packetSize += len(p.packetBody) + 1 // Length of Body field, plus 1 for null terminator
-	return packetSize + 1               // 1 byte for null terminator
+func (p Packet) size() int {
+	var packetSize = 0            // Do not count the size field
+	packetSize += 4               // 4 bytes for ID field
+	packetSize += 4               // 4 bytes for Type field
+	packetSize += len(p.Body) + 1 // Length of Body field, plus 1 for null terminator
+	return packetSize + 1         // 1 byte for null terminator
 }
 
 // serializePacket converts a packet struct into a byte slice containing the raw packet to be sent to the server
-func (p packet) serializePacket() []byte {
-	body := append([]byte(p.packetBody), 0) // Zero-terminate body string
-	size := p.size()                        // Avoid repeat calls
-	// Construct data slice
-	bytes := make([]byte, size+4)
-	binary.LittleEndian.PutUint32(bytes[0:4], uint32(size))
-	binary.LittleEndian.PutUint32(bytes[4:8], uint32(p.packetId))
-	binary.LittleEndian.PutUint32(bytes[8:12], uint32(p.packetType))
-	copy(bytes[12:], body)
-	if Debug {
-		_, _ = fmt.Fprintf(os.Stderr, "serialize packet with type %v id %v and body '%v'\n",
-			p.packetType, p.packetId, p.packetBody)
-	}
-	return bytes
+func (p Packet) serializePacket() []byte {
+	return p.appendPacket(make([]byte, 0, p.size()+4))
+}
+
+// appendPacket appends the raw packet, as returned by serializePacket, to dst and returns the extended slice. It does
+// not allocate if dst has room for the packet.
+func (p Packet) appendPacket(dst []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(p.size()))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(p.ID))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(p.Type))
+	dst = append(dst, p.Body...)
+	return append(dst, 0, 0) // Zero-terminate body string, then packet
 }
 
 // deserializePacket converts a raw packet as a byte slice to a packet struct. The bytes should NOT include any size
 // information, which should be received separately by implementation
-func deserializePacket(bytes []byte) (packet, error) {
+func deserializePacket(bytes []byte) (Packet, error) {
 	// Handle data too short
-	if len(bytes) < 10 {
-		return packet{}, errors.New("invalid data - too short")
+	if len(bytes) < minPacketSize {
+		return Packet{}, ErrPacketTruncated
 	}
 	// Handle data or body not zero terminated
 	if bytes[len(bytes)-1] != 0 {
-		return packet{}, errors.New("invalid data - not zero-terminated")
+		return Packet{}, ErrPacketNotTerminated
 	}
 	if bytes[len(bytes)-2] != 0 {
-		return packet{}, errors.New("invalid data - body not zero-terminated")
-	}
-	// Read ID
-	var packetId int
-	{
-		packetId32 := binary.LittleEndian.Uint32(bytes[0:4])
-		packetId = int(packetId32)
-	}
-	// Read type
-	var packetType int
-	{
-		packetType32 := binary.LittleEndian.Uint32(bytes[4:8])
-		packetType = int(packetType32)
+		return Packet{}, fmt.Errorf("%w (body)", ErrPacketNotTerminated)
 	}
+	// Read ID and type, both signed
+	r := wire.NewReader(bytes)
+	packetId := int(int32(r.Uint32()))
+	packetType := PacketType(r.Uint32())
 	var packetBody = string(bytes[8 : len(bytes)-2]) // -2 for null terminators on body and whole packet
-	//fmt.Printf("Packet type: %v, Packet ID: %v, Packet Body: '%v'\n", packetType, packetId, packetBody)
-	if Debug {
-		_, _ = fmt.Fprintf(os.Stderr, "deserialize packet with type %v id %v and body '%v'\n",
-			packetType, packetId, packetBody)
+	return Packet{ID: packetId, Type: packetType, Body: packetBody}, nil
+}
+
+// redacted stands in for passwords in log records.
+const redacted = "[redacted]"
+
+// A secret is a password held for later use. It prints as redacted, so that it cannot leak through formatting or
+// logging of the value holding it.
+type secret string
+
+func (secret) String() string {
+	return redacted
+}
+
+func (secret) GoString() string {
+	return redacted
+}
+
+func (secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// LogValue presents the packet in log records, with the password in the body of SERVERDATA_AUTH packets redacted.
+func (p Packet) LogValue() slog.Value {
+	body := p.Body
+	if p.Type == ServerdataAuth {
+		body = redacted
 	}
-	return packet{packetId: packetId, packetType: packetType, packetBody: packetBody}, nil
+	return slog.GroupValue(
+		slog.Int("id", p.ID),
+		slog.Int("type", int(p.Type)),
+		slog.Int("size", p.size()),
+		slog.String("body", body),
+	)
 }
 
-// TCP client that provides methods that implement RCON protocol
+// TCP client that provides methods that implement RCON protocol. The connection is usually a net.Conn, but any
+// io.ReadWriteCloser will do.
 type client struct {
-	con *net.Conn
+	con io.ReadWriteCloser
+	// log receives the packets sent and received; if nil, nothing is logged
+	log *slog.Logger
+	// maxSize is the largest size field accepted from the server; if zero, defaultMaxPacketSize is
+	maxSize int
+
+	// r buffers reads from con, so that the small packets making up most exchanges take few system calls. It is
+	// created on first use. It and the fields below are only used by whichever goroutine is receiving packets.
+	r *bufio.Reader
+	// buf holds the packet being received, and is reused from one packet to the next
+	buf []byte
 }
 
-// newClient creates a new client from host and port; it returns error on connection failure. Callers should
-// defer execution of close() on the returned client.
-func newClient(host string, port int) (*client, error) {
-	con, err := net.DialTimeout("tcp", host+":"+strconv.Itoa(port), 4*time.Second)
-	if Debug {
-		_, _ = fmt.Fprintln(os.Stderr, "open connection with error", err)
-	}
+// deadliner is implemented by connections, such as net.Conn, which support deadlines on reads and writes.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// newClient creates a new client from host and port, dialing as configured by o; it returns error on connection
+// failure. Dialing is abandoned if ctx is done first, in which case ctx.Err() is returned. Callers should defer
+// execution of close() on the returned client.
+func newClient(ctx context.Context, host string, port int, o options) (*client, error) {
+	con, err := o.dialServer(ctx, "tcp", host, port)
 	if err != nil {
-		netErr, ok := err.(net.Error)
-		if !ok {
-			return nil, err
-		}
-		return nil, ConnectionFailure{netErr}
+		return nil, err
 	}
 	return &client{
-		&con,
+		con:     con,
+		log:     o.logger,
+		maxSize: o.packetSizeLimit(),
 	}, nil
 }
 
 // sendPacket takes an RCON packet and sends it to the connection; it does not listen for a response. It returns
-// a non-nil error if the packet is malformed or if there is an error on send failure.
-func (c *client) sendPacket(p packet) error {
-	bytes := p.serializePacket()
-	// Check format
-	if bytes[len(bytes)-2] != 0 {
-		return errors.New("request body not a null terminated string")
-	} else if bytes[len(bytes)-1] != 0 {
-		return errors.New("request not null terminated")
-	}
-	// Send packet
-	{
-		if Debug {
-			_, err := fmt.Fprintln(os.Stderr, "send", bytes)
-			if err != nil {
-				return err
-			}
-		}
-		num, err := (*c.con).Write(bytes)
-		if err != nil {
-			return err
-		}
-		if num != len(bytes) {
-			return errors.New("failed to send full packet")
+// a non-nil error if there is an error on send failure.
+func (c *client) sendPacket(p Packet) error {
+	return c.sendPackets(p)
+}
+
+// sendPackets is like sendPacket, but sends any number of packets with a single write, in the order given. The
+// encoded packets are zeroed once sent if any of them holds a password.
+func (c *client) sendPackets(ps ...Packet) error {
+	bufp := writeBuffers.Get().(*[]byte)
+	buf := (*bufp)[:0]
+	hasSecret := false
+	debug := c.debugEnabled()
+	for _, p := range ps {
+		buf = p.appendPacket(buf)
+		hasSecret = hasSecret || p.Type == ServerdataAuth
+		if debug {
+			c.logger().Debug("send packet", "packet", p)
 		}
 	}
+	defer releaseWriteBuffer(bufp, buf, hasSecret)
+
+	num, err := c.con.Write(buf)
+	if err != nil {
+		return err
+	}
+	if num != len(buf) {
+		return errors.New("failed to send full packet")
+	}
 	return nil
 }
 
 // receivePacket receives an RCON packet from the connection, blocking until one is available. It returns a
-// non-nil error on read failure.
-func (c *client) receivePacket() (packet, error) {
-	// Read response
-	var response packet
+// non-nil error on read failure, and a *PacketSizeError if the size field is out of range, in which case the rest of
+// the packet is left unread.
+func (c *client) receivePacket() (Packet, error) {
+	if c.r == nil {
+		c.r = bufio.NewReader(c.con)
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, defaultMaxPacketSize)
+	}
+	response, buf, err := readPacket(c.r, c.buf, c.maxPacketSize())
+	c.buf = buf
+	if err != nil {
+		return Packet{}, err
+	}
+	if c.debugEnabled() {
+		c.logger().Debug("receive packet", "packet", response)
+	}
+	return response, nil
+}
+
+// readPacket reads a packet of at most maxSize bytes from r. It reads into buf if it is large enough, and returns the
+// buffer to use for the next packet: buf, or a larger one if buf was outgrown by a packet small enough to be worth
+// keeping a buffer for. Only the body of the packet is allocated.
+func readPacket(r io.Reader, buf []byte, maxSize int) (Packet, []byte, error) {
+	if len(buf) < 4 {
+		buf = make([]byte, 4)
+	}
 	// read size
-	var size int
-	{
-		buf := make([]byte, 4)
-		num, err := io.ReadFull(*c.con, buf)
-		if Debug {
-			_, _ = fmt.Fprintln(os.Stderr, "receive raw size", buf, "with error", err)
-		}
-		if err != nil {
-			return packet{}, err
-		} else if num < 4 {
-			return packet{}, errors.New("failed to read size of packet; could not read first word")
-		}
-		size32 := binary.LittleEndian.Uint32(buf)
-		if num == 0 {
-			return packet{}, errors.New("failed to read size of packet; buffer too small")
-		} else if num < 0 {
-			return packet{}, errors.New("failed to read size of packet; buffer too small")
-		}
-		size = int(size32)
+	_, err := io.ReadFull(r, buf[:4])
+	if err != nil {
+		return Packet{}, buf, err
+	}
+	// The size field is signed, so a huge size reads as negative
+	size := int(int32(binary.LittleEndian.Uint32(buf)))
+	if size < minPacketSize || size > maxSize {
+		return Packet{}, buf, &PacketSizeError{Size: size, Min: minPacketSize, Max: maxSize}
 	}
 	// read size number of bytes
-	{
-		var err error
-		buf := make([]byte, size)
-		num, err := io.ReadFull(*c.con, buf)
-		if Debug {
-			_, _ = fmt.Fprintln(os.Stderr, "receive raw payload", buf, "with error", err)
-		}
-		if err != nil {
-			return packet{}, err
-		} else if num < size {
-			return packet{}, errors.New("failed to read packet up to full size")
-		}
-		response, err = deserializePacket(buf)
-		if err != nil {
-			return packet{}, err
+	data := buf
+	if size > len(buf) {
+		data = make([]byte, size)
+		if size <= maxRetainedBuffer {
+			buf = data
 		}
 	}
-	return response, nil
+	data = data[:size]
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return Packet{}, buf, err
+	}
+	p, err := deserializePacket(data)
+	return p, buf, err
+}
+
+// releaseWriteBuffer returns bufp, which buf has been encoded into, to writeBuffers once buf has been written, first
+// zeroing it if it holds a password. Very large buffers are left to the garbage collector instead.
+func releaseWriteBuffer(bufp *[]byte, buf []byte, hasSecret bool) {
+	if hasSecret {
+		clear(buf)
+	}
+	if cap(buf) <= maxRetainedBuffer {
+		*bufp = buf[:0]
+		writeBuffers.Put(bufp)
+	}
+}
+
+// maxPacketSize returns the largest size field the client accepts.
+func (c *client) maxPacketSize() int {
+	if c.maxSize == 0 {
+		return defaultMaxPacketSize
+	}
+	return c.maxSize
+}
+
+// debugEnabled reports whether the client logs at debug level, so that building records which would be discarded can
+// be skipped.
+func (c *client) debugEnabled() bool {
+	return c.logger().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// logger returns the logger the client reports to.
+func (c *client) logger() *slog.Logger {
+	if c.log == nil {
+		return discard.Logger
+	}
+	return c.log
+}
+
+// withContext runs f, interrupting any read or write it is blocked on once ctx is done. The deadline of ctx, if any,
+// is applied to the connection for the duration of f. If ctx ends before f returns, ctx.Err() is returned in place of
+// the error produced by the interrupted operation.
+func (c *client) withContext(ctx context.Context, f func() error) error {
+	if d, ok := c.con.(deadliner); ok {
+		return wire.Interruptible(ctx, d.SetDeadline, f)
+	}
+	return wire.Interruptible(ctx, c.closeOnExpiry, f)
+}
+
+// withWriteContext is like withContext, but only interrupts writes; reads in progress on other goroutines are left
+// undisturbed.
+func (c *client) withWriteContext(ctx context.Context, f func() error) error {
+	if d, ok := c.con.(deadliner); ok {
+		return wire.Interruptible(ctx, d.SetWriteDeadline, f)
+	}
+	return wire.Interruptible(ctx, c.closeOnExpiry, f)
+}
+
+// withReadContext is like withContext, but only interrupts reads; writes in progress on other goroutines are left
+// undisturbed.
+func (c *client) withReadContext(ctx context.Context, f func() error) error {
+	if d, ok := c.con.(interface{ SetReadDeadline(t time.Time) error }); ok {
+		return wire.Interruptible(ctx, d.SetReadDeadline, f)
+	}
+	return wire.Interruptible(ctx, c.closeOnExpiry, f)
+}
+
+// closeOnExpiry stands in for setting a deadline on connections which do not support them. It cannot schedule a
+// deadline, but closes the connection when given one which has already passed; this unblocks pending operations all
+// the same, at the cost of the connection.
+func (c *client) closeOnExpiry(t time.Time) error {
+	if t.IsZero() || t.After(time.Now()) {
+		return nil
+	}
+	return c.con.Close()
 }
 
 // close closes the connection; it is intended to be deferred on newClient call.
 func (c *client) close() {
-	if Debug {
-		_, _ = fmt.Fprintln(os.Stderr, "close connection")
-	}
+	c.logger().Debug("close connection")
 	if c.con == nil {
 		return
 	}
-	err := (*c.con).Close()
+	err := c.con.Close()
 	if err != nil {
 		return
 	}
 }
 
 // ConnectionFailure is an error type occurring whenever connection fails for whatever reason; it provides clients
-// a way to check and handle this behaviour.
+// a way to check and handle this behaviour. It wraps the network error which caused it.
 type ConnectionFailure struct {
 	err net.Error
 }
@@ -235,3 +402,7 @@ func (e ConnectionFailure) Error() string {
 func (e ConnectionFailure) Timeout() bool {
 	return e.err.Timeout()
 }
+
+func (e ConnectionFailure) Unwrap() error {
+	return e.err
+}
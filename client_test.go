@@ -17,20 +17,29 @@ https://www.gnu.org/licenses.
 package rcon
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"math"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPacketSize(t *testing.T) {
 	cases := []struct {
-		in   packet
+		in   Packet
 		want int
 	}{
-		{packet{0, 0, ""}, 10},
-		{packet{math.MaxInt, 0, ""}, 10},
-		{packet{1, serverdataAuth, "abcdefghijklmnopqrstuvwxyz"}, 36},
+		{Packet{0, 0, ""}, 10},
+		{Packet{math.MaxInt, 0, ""}, 10},
+		{Packet{1, ServerdataAuth, "abcdefghijklmnopqrstuvwxyz"}, 36},
 	}
 	for _, c := range cases {
 		got := c.in.size()
@@ -42,11 +51,11 @@ func TestPacketSize(t *testing.T) {
 
 func TestSerialize(t *testing.T) {
 	cases := []struct {
-		in   packet
+		in   Packet
 		want []byte
 	}{
-		{packet{0, 0, ""}, []byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
-		{packet{0, serverdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{Packet{0, 0, ""}, []byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{Packet{0, ServerdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
 			[]byte{0, 1, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 0, 0}},
 	}
 	for _, c := range cases {
@@ -60,11 +69,11 @@ func TestSerialize(t *testing.T) {
 func TestDeserialize(t *testing.T) {
 	cases := []struct {
 		in   []byte
-		want packet
+		want Packet
 	}{
-		{[]byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, packet{0, 0, ""}},
+		{[]byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Packet{0, 0, ""}},
 		{[]byte{0, 1, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 0, 0},
-			packet{0, serverdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
+			Packet{0, ServerdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
 	}
 	for _, c := range cases {
 		got, err := deserializePacket(c.in[4:])
@@ -79,18 +88,18 @@ func TestDeserialize(t *testing.T) {
 
 func TestSendPacket(t *testing.T) {
 	cases := []struct {
-		in   packet
+		in   Packet
 		want []byte
 	}{
-		{packet{0, 0, ""}, []byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
-		{packet{0, serverdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{Packet{0, 0, ""}, []byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{Packet{0, ServerdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
 			[]byte{0, 1, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 0, 0}},
 	}
 	for _, c := range cases {
 		serverCon, clientCon := net.Pipe()
-		client := client{con: &clientCon}
+		client := client{con: clientCon}
 		go func(testCase struct {
-			in   packet
+			in   Packet
 			want []byte
 		}) {
 			err := client.sendPacket(testCase.in)
@@ -115,18 +124,18 @@ func TestSendPacket(t *testing.T) {
 func TestReceivePacket(t *testing.T) {
 	cases := []struct {
 		in   []byte
-		want packet
+		want Packet
 	}{
-		{[]byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, packet{0, 0, ""}},
+		{[]byte{10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Packet{0, 0, ""}},
 		{[]byte{0, 1, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 0, 0},
-			packet{0, serverdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
+			Packet{0, ServerdataAuth, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
 	}
 	for _, c := range cases {
 		serverCon, clientCon := net.Pipe()
-		client := client{con: &clientCon}
+		client := client{con: clientCon}
 		go func(testCase struct {
 			in   []byte
-			want packet
+			want Packet
 		}) {
 			receivePacket, err := client.receivePacket()
 			if err != nil {
@@ -145,3 +154,226 @@ func TestReceivePacket(t *testing.T) {
 		}
 	}
 }
+
+func TestWithContext(t *testing.T) {
+	serverCon, clientCon := net.Pipe()
+	defer serverCon.Close()
+	client := client{con: clientCon}
+	defer client.close()
+
+	// The server never answers, so only the context can end the read
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.withContext(ctx, func() error {
+		_, err := client.receivePacket()
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v from timed out receive, got %v", context.DeadlineExceeded, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err = client.withContext(ctx, func() error {
+		_, err := client.receivePacket()
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected %v from cancelled receive, got %v", context.Canceled, err)
+	}
+}
+
+func TestLogRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	conn := newEchoConnection(t, WithLogger(logger))
+	if _, err := conn.SendCommand("echo logged"); err != nil {
+		t.Fatalf("Encountered error while sending command: %v", err)
+	}
+	conn.Close()
+
+	out := buf.String()
+	if strings.Contains(out, "body="+testPassword) {
+		t.Errorf("Expected password to be redacted from log, got:\n%v", out)
+	}
+	for _, want := range []string{"packet.type=3 packet.size=", "packet.body=" + redacted, "packet.body=\"echo logged\""} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected log to contain %q, got:\n%v", want, out)
+		}
+	}
+}
+
+// retainingWriter keeps the buffers written to it, rather than copies of them.
+type retainingWriter struct {
+	io.ReadCloser
+	written [][]byte
+}
+
+func (w *retainingWriter) Write(p []byte) (int, error) {
+	w.written = append(w.written, p)
+	return len(p), nil
+}
+
+func TestAuthPacketZeroed(t *testing.T) {
+	w := &retainingWriter{}
+	c := &client{con: w}
+	if err := c.sendPacket(Packet{0, ServerdataAuth, testPassword}); err != nil {
+		t.Fatalf("Encountered error while sending packet: %v", err)
+	}
+	if len(w.written) != 1 || !bytes.Equal(w.written[0], make([]byte, len(w.written[0]))) {
+		t.Errorf("Expected auth packet to be zeroed after sending, got %v", w.written)
+	}
+	if err := c.sendPacket(Packet{1, ServerdataExeccommand, "echo kept"}); err != nil {
+		t.Fatalf("Encountered error while sending packet: %v", err)
+	}
+	if len(w.written) != 2 || !bytes.Contains(w.written[1], []byte("echo kept")) {
+		t.Errorf("Expected command packet to be left as sent, got %v", w.written)
+	}
+}
+
+func TestSecretRedaction(t *testing.T) {
+	const password = "hunter2"
+	s := secret(password)
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("secret", "password", s)
+	cases := []string{
+		fmt.Sprint(s),
+		fmt.Sprintf("%v", s),
+		fmt.Sprintf("%#v", s),
+		fmt.Errorf("failed with %v", s).Error(),
+		buf.String(),
+	}
+	for _, c := range cases {
+		if strings.Contains(c, password) || !strings.Contains(c, redacted) {
+			t.Errorf("Expected password to be redacted, got %v", c)
+		}
+	}
+}
+
+func FuzzDeserializePacket(f *testing.F) {
+	f.Add(Packet{0, ServerdataAuth, testPassword}.serializePacket()[4:])
+	f.Add(Packet{-1, ServerdataAuthResponse, ""}.serializePacket()[4:])
+	f.Add(Packet{7, ServerdataResponseValue, "\x00\x01\x00\x00"}.serializePacket()[4:])
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0})
+	f.Add([]byte("garbage that is not a packet"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := deserializePacket(data)
+		if err != nil {
+			return
+		}
+		// Every valid packet is read back exactly as written
+		if serialized := p.serializePacket()[4:]; !bytes.Equal(serialized, data) {
+			t.Errorf("Deserializing %v, got %+v, which serializes to %v", data, p, serialized)
+		}
+	})
+}
+
+func FuzzReceivePacket(f *testing.F) {
+	f.Add(append(Packet{1, ServerdataResponseValue, "hello"}.serializePacket(),
+		Packet{2, ServerdataResponseValue, ""}.serializePacket()...))
+	f.Add(binary.LittleEndian.AppendUint32(nil, defaultMaxPacketSize+1))
+	f.Add(binary.LittleEndian.AppendUint32(nil, 0xFFFFFFFF))
+	f.Add(binary.LittleEndian.AppendUint32(nil, 9))
+	f.Add(binary.LittleEndian.AppendUint32([]byte{0, 0}, 100))
+	f.Add([]byte("garbage that is not a packet"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := &client{con: &retainingWriter{ReadCloser: io.NopCloser(bytes.NewReader(data))}}
+		read := 0
+		for {
+			p, err := c.receivePacket()
+			var sizeErr *PacketSizeError
+			if errors.As(err, &sizeErr) && sizeErr.Size >= minPacketSize && sizeErr.Size <= defaultMaxPacketSize {
+				t.Errorf("Receiving %v, got size error for valid size %v", data, sizeErr.Size)
+			}
+			if err != nil {
+				return
+			}
+			serialized := p.serializePacket()
+			if len(serialized)-4 > defaultMaxPacketSize {
+				t.Errorf("Receiving %v, accepted packet of size %v", data, len(serialized)-4)
+			}
+			if !bytes.Equal(serialized, data[read:read+len(serialized)]) {
+				t.Errorf("Receiving %v, got %+v, which serializes to %v", data, p, serialized)
+			}
+			read += len(serialized)
+		}
+	})
+}
+
+// loopConn reads the same data over and over without end, and discards what is written to it.
+type loopConn struct {
+	data []byte
+	off  int
+}
+
+func (l *loopConn) Read(p []byte) (int, error) {
+	n := copy(p, l.data[l.off:])
+	l.off = (l.off + n) % len(l.data)
+	return n, nil
+}
+
+func (l *loopConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (l *loopConn) Close() error {
+	return nil
+}
+
+func TestCodecAllocs(t *testing.T) {
+	request := Packet{1, ServerdataExeccommand, "status"}
+	ping := Packet{2, ServerdataResponseValue, ""}
+	var buf []byte
+	sender := &client{con: &loopConn{}}
+	emptyReceiver := &client{con: &loopConn{data: ping.serializePacket()}}
+	bodyReceiver := &client{con: &loopConn{data: request.serializePacket()}}
+	cases := []struct {
+		name string
+		f    func()
+		want float64
+	}{
+		{"appending packet", func() { buf = request.appendPacket(buf[:0]) }, 0},
+		{"sending packets", func() { _ = sender.sendPackets(request, ping) }, 0},
+		{"receiving packet with empty body", func() { _, _ = emptyReceiver.receivePacket() }, 0},
+		// The body is copied out into a string
+		{"receiving packet with body", func() { _, _ = bodyReceiver.receivePacket() }, 1},
+	}
+	for _, c := range cases {
+		c.f() // Warm up buffers and pools
+		if got := testing.AllocsPerRun(100, c.f); got > c.want {
+			t.Errorf("%v, expected at most %v allocations, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func BenchmarkSendPackets(b *testing.B) {
+	c := &client{con: &loopConn{}}
+	request := Packet{1, ServerdataExeccommand, "status"}
+	ping := Packet{2, ServerdataResponseValue, ""}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := c.sendPackets(request, ping); err != nil {
+			b.Fatalf("Encountered error while sending packets: %v", err)
+		}
+	}
+}
+
+func BenchmarkReceivePacket(b *testing.B) {
+	// A typical exchange of a command: its response, then the two answers to the ping packet
+	var data []byte
+	for _, p := range []Packet{
+		{1, ServerdataResponseValue, strings.Repeat("x", 200)},
+		{2, ServerdataResponseValue, ""},
+		{2, ServerdataResponseValue, "\x00\x01\x00\x00"},
+	} {
+		data = p.appendPacket(data)
+	}
+	c := &client{con: &loopConn{data: data}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.receivePacket(); err != nil {
+			b.Fatalf("Encountered error while receiving packet: %v", err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time           string  `json:"time"`
+	User           string  `json:"user"`
+	Server         string  `json:"server,omitempty"`
+	Address        string  `json:"address"`
+	ProxyUser      string  `json:"proxy_user,omitempty"`
+	ProxyRemote    string  `json:"proxy_remote,omitempty"`
+	Command        string  `json:"command"`
+	ResponseSize   int     `json:"response_size"`
+	ResponseSHA256 string  `json:"response_sha256"`
+	Duration       float64 `json:"duration_seconds"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// An auditLog appends an entry to a JSON Lines file for every command sent, rotating the file once it grows too large:
+// the file is renamed with the suffix .1, the file with the suffix .1 to .2, and so on, discarding the oldest.
+type auditLog struct {
+	path    string
+	maxSize int64
+	backups int
+
+	// user, server and address are the same for every entry
+	user    string
+	server  string
+	address string
+
+	// mu guards the fields below
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// openAuditLog opens the audit log at path for appending, creating it if need be. The file is rotated once it would
+// grow beyond maxSize bytes, keeping the given number of rotated files. Entries name the server by its name in the
+// config file, which may be empty, and its address.
+func openAuditLog(path string, maxSize int64, backups int, server string, address string) (*auditLog, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("maximum size must be positive")
+	}
+	if backups < 0 {
+		return nil, errors.New("number of backups cannot be negative")
+	}
+	l := &auditLog{
+		path:    path,
+		maxSize: maxSize,
+		backups: backups,
+		user:    currentUser(),
+		server:  server,
+		address: address,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// hook records a command sent to the server; it is passed to rcon.WithAuditHook. Commands forwarded by proxy mode are
+// recorded along with the proxy user who sent them.
+func (l *auditLog) hook(ctx context.Context, record rcon.AuditRecord) {
+	sum := sha256.Sum256([]byte(record.Response))
+	entry := auditEntry{
+		Time:           record.Time.UTC().Format(time.RFC3339Nano),
+		User:           l.user,
+		Server:         l.server,
+		Address:        l.address,
+		Command:        record.Command,
+		ResponseSize:   len(record.Response),
+		ResponseSHA256: hex.EncodeToString(sum[:]),
+		Duration:       record.Duration.Seconds(),
+	}
+	if req, ok := ctx.Value(proxyRequestKey{}).(*rcon.Request); ok {
+		entry.ProxyUser = req.User
+		if req.RemoteAddr != nil {
+			entry.ProxyRemote = req.RemoteAddr.String()
+		}
+	}
+	if record.Err != nil {
+		entry.Error = record.Err.Error()
+	}
+	if err := l.write(entry); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Could not write audit log:", err)
+	}
+}
+
+// write appends entry to the file as a single line, rotating the file first if it would grow too large.
+func (l *auditLog) write(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errors.New("audit log is closed")
+	}
+	if l.file == nil {
+		// A previous rotation failed to reopen the file
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// open opens the file for appending, creating it readable only by its owner if it does not exist.
+func (l *auditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the file aside, shifting the older rotated files along, and opens a new one in its place.
+func (l *auditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	if l.backups == 0 {
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+	} else {
+		for i := l.backups - 1; i >= 1; i-- {
+			err := os.Rename(l.backupPath(i), l.backupPath(i+1))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, l.backupPath(1)); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// backupPath returns the path of the rotated file with the given number, 1 being the most recent.
+func (l *auditLog) backupPath(i int) string {
+	return l.path + "." + strconv.Itoa(i)
+}
+
+// Close closes the file.
+func (l *auditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// currentUser returns the name of the user running the program, or an empty string if it cannot be found.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/vibeisveryo/rcon"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAuditLines returns the commands recorded in the audit log file at path, in order.
+func readAuditLines(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Encountered error while reading %v: %v", path, err)
+	}
+	var commands []string
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("Encountered error while decoding %q: %v", line, err)
+		}
+		commands = append(commands, entry.Command)
+	}
+	return commands
+}
+
+// record sends a record of cmd to the audit log, failing the test if it cannot be written.
+func record(t *testing.T, l *auditLog, cmd string) {
+	err := l.write(auditEntry{Time: "2023-11-05T18:04:12Z", Command: cmd})
+	if err != nil {
+		t.Fatalf("Encountered error while writing %q: %v", cmd, err)
+	}
+}
+
+// entrySize is the size of the line record writes for a one-character command.
+func entrySize(t *testing.T) int64 {
+	line, err := json.Marshal(auditEntry{Time: "2023-11-05T18:04:12Z", Command: "a"})
+	if err != nil {
+		t.Fatalf("Encountered error while encoding entry: %v", err)
+	}
+	return int64(len(line)) + 1
+}
+
+func TestAuditLogEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := openAuditLog(path, 1<<20, 1, "someserver", "172.0.0.1:27015")
+	if err != nil {
+		t.Fatalf("Encountered error while opening audit log: %v", err)
+	}
+	start := time.Date(2023, 11, 5, 18, 4, 12, 0, time.FixedZone("EST", -5*60*60))
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000}
+	ctx := context.WithValue(context.Background(), proxyRequestKey{},
+		&rcon.Request{Command: "kick bob", User: "somemoderator", RemoteAddr: remote})
+	l.hook(context.Background(), rcon.AuditRecord{Time: start, Command: "status", Response: "hostname: test\n",
+		Duration: 1500 * time.Millisecond})
+	l.hook(ctx, rcon.AuditRecord{Time: start, Command: "kick bob", Duration: time.Second,
+		Err: errors.New("connection reset")})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Encountered error while closing audit log: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Encountered error while reading audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %v: %q", len(lines), data)
+	}
+	outputSum := sha256.Sum256([]byte("hostname: test\n"))
+	emptySum := sha256.Sum256(nil)
+	user := currentUser()
+	cases := []struct {
+		line string
+		want map[string]any
+	}{
+		{lines[0], map[string]any{
+			"time":             "2023-11-05T23:04:12Z",
+			"user":             user,
+			"server":           "someserver",
+			"address":          "172.0.0.1:27015",
+			"command":          "status",
+			"response_size":    float64(15),
+			"response_sha256":  hex.EncodeToString(outputSum[:]),
+			"duration_seconds": 1.5,
+		}},
+		{lines[1], map[string]any{
+			"time":             "2023-11-05T23:04:12Z",
+			"user":             user,
+			"server":           "someserver",
+			"address":          "172.0.0.1:27015",
+			"proxy_user":       "somemoderator",
+			"proxy_remote":     "10.0.0.1:50000",
+			"command":          "kick bob",
+			"response_size":    float64(0),
+			"response_sha256":  hex.EncodeToString(emptySum[:]),
+			"duration_seconds": float64(1),
+			"error":            "connection reset",
+		}},
+	}
+	for _, c := range cases {
+		var got map[string]any
+		if err := json.Unmarshal([]byte(c.line), &got); err != nil {
+			t.Fatalf("Encountered error while decoding %q: %v", c.line, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("expected %v, got %v", c.want, got)
+		}
+	}
+}
+
+func TestAuditLogReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for _, cmd := range []string{"a", "b"} {
+		l, err := openAuditLog(path, 1<<20, 1, "", "")
+		if err != nil {
+			t.Fatalf("Encountered error while opening audit log: %v", err)
+		}
+		record(t, l, cmd)
+		if err := l.Close(); err != nil {
+			t.Fatalf("Encountered error while closing audit log: %v", err)
+		}
+	}
+	want := []string{"a", "b"}
+	if got := readAuditLines(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Encountered error while checking audit log: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		t.Errorf("Expected audit log to be readable only by its owner, got mode %v", perm)
+	}
+
+	// Records are no longer accepted once the log is closed
+	l, err := openAuditLog(path, 1<<20, 1, "", "")
+	if err != nil {
+		t.Fatalf("Encountered error while opening audit log: %v", err)
+	}
+	_ = l.Close()
+	if err := l.write(auditEntry{Command: "c"}); err == nil {
+		t.Errorf("Expected error writing to closed audit log")
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	size := entrySize(t)
+	cases := []struct {
+		backups int
+		// commands are recorded one after the other, with room for two in each file
+		commands []string
+		// want lists the commands in each file: the log itself, then the rotated files from .1 on
+		want [][]string
+	}{
+		{2, []string{"a", "b"}, [][]string{{"a", "b"}}},
+		{2, []string{"a", "b", "c"}, [][]string{{"c"}, {"a", "b"}}},
+		{2, []string{"a", "b", "c", "d", "e"}, [][]string{{"e"}, {"c", "d"}, {"a", "b"}}},
+		{2, []string{"a", "b", "c", "d", "e", "f", "g"}, [][]string{{"g"}, {"e", "f"}, {"c", "d"}}},
+		{1, []string{"a", "b", "c", "d", "e"}, [][]string{{"e"}, {"c", "d"}}},
+		{0, []string{"a", "b", "c", "d", "e"}, [][]string{{"e"}}},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "audit.jsonl")
+		l, err := openAuditLog(path, 2*size, c.backups, "", "")
+		if err != nil {
+			t.Fatalf("Encountered error while opening audit log: %v", err)
+		}
+		for _, cmd := range c.commands {
+			record(t, l, cmd)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatalf("Encountered error while closing audit log: %v", err)
+		}
+
+		var got [][]string
+		for i := range c.want {
+			p := path
+			if i > 0 {
+				p = l.backupPath(i)
+			}
+			got = append(got, readAuditLines(t, p))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Backups %v, commands %v, expected %v, got %v", c.backups, c.commands, c.want, got)
+		}
+		// No more files are kept than asked for
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Encountered error while listing %v: %v", dir, err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		sort.Strings(names)
+		if len(names) != len(c.want) {
+			t.Errorf("Backups %v, commands %v, expected %v files, got %v", c.backups, c.commands, len(c.want),
+				names)
+		}
+	}
+}
+
+func TestAuditLogRotationExisting(t *testing.T) {
+	// A log which already holds records counts towards the limit once reopened
+	size := entrySize(t)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for _, cmd := range []string{"a", "b", "c"} {
+		l, err := openAuditLog(path, 2*size, 1, "", "")
+		if err != nil {
+			t.Fatalf("Encountered error while opening audit log: %v", err)
+		}
+		record(t, l, cmd)
+		_ = l.Close()
+	}
+	cases := []struct {
+		path string
+		want []string
+	}{
+		{path, []string{"c"}},
+		{path + ".1", []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		if got := readAuditLines(t, c.path); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v, expected %v, got %v", filepath.Base(c.path), c.want, got)
+		}
+	}
+}
+
+func TestOpenAuditLogInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cases := []struct {
+		maxSize int64
+		backups int
+	}{
+		{0, 1},
+		{-1, 1},
+		{1 << 20, -1},
+	}
+	for _, c := range cases {
+		if _, err := openAuditLog(path, c.maxSize, c.backups, "", ""); err == nil {
+			t.Errorf("Max size %v, backups %v, expected error, got nil", c.maxSize, c.backups)
+		}
+	}
+}
@@ -32,28 +32,59 @@ const defaultFileContent = `# RCON Config
 # hostname = "172.0.0.1"
 # port = 27015
 # password = "somepassword"
+# Alternatively, to keep the password out of this file, use one of:
+# password_file = "/path/to/file"
+# password_env = "SOMESERVER_RCON_PASSWORD"
+# password_command = "pass show rcon/someservername"
+# protocol = "source"
+# dialect = "srcds"
+
+# To share a server with others without handing out its password, run "rcon -s someservername proxy" and give each
+# user a password of their own, as below. Commands are matched by name, and may use * as a wildcard; deny rules take
+# precedence over allow rules, and commands matching neither are denied. Since alias and exec can run other commands,
+# users allowed * should be denied them.
+# [proxy]
+# listen = ":27016"
+# [proxy.users.somemoderator]
+# password = "moderatorpassword"
+# allow = ["status", "kick", "say"]
+# [proxy.users.someadmin]
+# password_env = "SOMEADMIN_PROXY_PASSWORD"
+# allow = ["*"]
+# deny = ["rcon_password", "sv_cheats", "alias", "exec"]
 
 `
 
+// proxyTableName is the name of the table holding the configuration of proxy mode, rather than that of a server.
+const proxyTableName = "proxy"
+
 type configMap map[string]server
 
 type server struct {
 	Host     string `toml:"hostname"` // referred to as hostname in config file for backwards compatibility
 	Port     int    `toml:"port"`
 	Password string `toml:"password"`
+	Protocol string `toml:"protocol"`
+	Dialect  string `toml:"dialect"`
+
+	PasswordFile    string `toml:"password_file"`
+	PasswordEnv     string `toml:"password_env"`
+	PasswordCommand string `toml:"password_command"`
 }
 
-func readConfig() (configMap, error) {
+// readConfig reads the configuration file, creating it if it does not exist, and returns the servers and proxy
+// configuration it holds.
+func readConfig() (configMap, proxyConfig, error) {
 	// If config directory doesn't exist, make it
 	configDirPath, err := os.UserConfigDir()
 	if err != nil {
-		return nil, err
+		return nil, proxyConfig{}, err
 	}
 	configSubdirPath := path.Join(configDirPath, configSubdirName)
 	err = os.Mkdir(configSubdirPath, 0755)
 	if err != nil {
 		if !errors.Is(err, os.ErrExist) {
-			return nil, err
+			return nil, proxyConfig{}, err
 		}
 		// if it already exists no need to do anything
 	}
@@ -64,21 +95,35 @@ func readConfig() (configMap, error) {
 		if errors.Is(err, os.ErrNotExist) {
 			err := os.WriteFile(configFilePath, []byte(defaultFileContent), 0644)
 			if err != nil {
-				return nil, err
+				return nil, proxyConfig{}, err
 			}
 			configData = defaultFileContent
 		} else {
-			return nil, err
+			return nil, proxyConfig{}, err
 		}
 	} else {
 		configData = string(configDataBytes)
 	}
 
-	// Decode config info
-	var config configMap
-	_, err = toml.Decode(configData, &config)
+	// Decode config info; every table is a server, other than that configuring proxy mode
+	var tables map[string]toml.Primitive
+	metadata, err := toml.Decode(configData, &tables)
 	if err != nil {
-		return nil, err
+		return nil, proxyConfig{}, err
+	}
+	config := make(configMap)
+	var proxy proxyConfig
+	for name, table := range tables {
+		if name == proxyTableName {
+			err = metadata.PrimitiveDecode(table, &proxy)
+		} else {
+			var s server
+			err = metadata.PrimitiveDecode(table, &s)
+			config[name] = s
+		}
+		if err != nil {
+			return nil, proxyConfig{}, err
+		}
 	}
-	return config, nil
+	return config, proxy, nil
 }
@@ -1,10 +1,16 @@
 module github.com/vibeisveryo/rcon/cmd/rcon
 
-go 1.19
+go 1.21
 
 require (
 	github.com/BurntSushi/toml v1.2.1
 	github.com/cheynewallace/tabby v1.1.1
 	github.com/spf13/pflag v1.0.5
 	github.com/vibeisveryo/rcon v0.1.1
+	golang.org/x/term v0.15.0
+)
+
+require (
+	github.com/gorilla/websocket v1.5.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 )
@@ -19,13 +19,16 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/cheynewallace/tabby"
 	flag "github.com/spf13/pflag"
 	"github.com/vibeisveryo/rcon"
 	"io"
+	"log/slog"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 )
@@ -37,6 +40,8 @@ func usage() {
 	syntaxString += "Usage:\n"
 	syntaxString += " rcon [options]\n"
 	syntaxString += " rcon [options] command\n"
+	syntaxString += " rcon [options] query [info] [players] [rules]\n"
+	syntaxString += " rcon [options] proxy\n"
 
 	var optionsString string
 	optionsString += "Options:\n"
@@ -48,7 +53,11 @@ func usage() {
 		if f.Hidden {
 			return
 		}
-		options.AddLine("-"+f.Shorthand+",", "--"+f.Name, f.Usage)
+		shorthand := ""
+		if f.Shorthand != "" {
+			shorthand = "-" + f.Shorthand + ","
+		}
+		options.AddLine(shorthand, "--"+f.Name, f.Usage)
 	})
 	options.Print()
 	optionsString += buf.String()
@@ -60,14 +69,29 @@ func mainWithCode() int {
 	flagHost := flag.StringP("host", "H", "", "Hostname or IP")
 	flagPort := flag.IntP("port", "p", 27015, "Port")
 	flagPassword := flag.StringP("password", "P", "", "RCON Password")
+	flagPasswordFile := flag.String("password-file", "", "Read the password from the first line of a file")
+	flagPasswordEnv := flag.String("password-env", "", "Read the password from an environment variable")
+	flagPasswordCommand := flag.String("password-command", "", "Read the password from the output of a shell command")
 	flagDebug := flag.BoolP("debug", "d", false, "Additional output for debug purposes")
+	flagAuditLog := flag.String("audit-log", "", "Append a JSON Lines record of every command sent to a file")
+	flagAuditLogMaxSize := flag.Int("audit-log-max-size", 10, "Size in MiB at which the audit log is rotated")
+	flagAuditLogBackups := flag.Int("audit-log-backups", 5, "Number of rotated audit logs to keep")
 	flagHelp := flag.BoolP("help", "h", false, "Show this help text")
 	flagServer := flag.StringP("server", "s", "", "Server from config file to select")
+	flagReconnect := flag.BoolP("reconnect", "r", false, "Reconnect automatically if the connection drops")
+	flagProtocol := flag.String("protocol", "source",
+		"Protocol spoken by the server: "+strings.Join(protocolNames, ", "))
+	flagDialect := flag.String("dialect", "srcds",
+		"Dialect of the source protocol spoken by the server: "+strings.Join(rcon.DialectNames(), ", "))
 	flag.CommandLine.SortFlags = false
 	flag.CommandLine.Usage = usage
 	flag.Parse()
 	args := flag.Args()
-	rcon.Debug = *flagDebug
+	logger := newLogger(*flagDebug)
+	// Query mode asks the server about itself without logging in
+	queryMode := len(args) != 0 && args[0] == "query"
+	// Proxy mode stands between the server and clients of its own
+	proxyMode := len(args) != 0 && args[0] == "proxy"
 
 	// Show help text if requested, then exit
 	if *flagHelp {
@@ -76,7 +100,7 @@ func mainWithCode() int {
 	}
 
 	// Read config file
-	config, err := readConfig()
+	config, proxy, err := readConfig()
 	if err != nil {
 		panic(err)
 	}
@@ -97,7 +121,19 @@ func mainWithCode() int {
 		} else {
 			*flagPort = 27015
 		}
-		*flagPassword = selectedServer.Password
+		// A password given on the command line, in any form, takes precedence over the config file
+		if !changedAny("password", "password-file", "password-env", "password-command") {
+			*flagPassword = selectedServer.Password
+			*flagPasswordFile = selectedServer.PasswordFile
+			*flagPasswordEnv = selectedServer.PasswordEnv
+			*flagPasswordCommand = selectedServer.PasswordCommand
+		}
+		if selectedServer.Protocol != "" && !flag.CommandLine.Changed("protocol") {
+			*flagProtocol = selectedServer.Protocol
+		}
+		if selectedServer.Dialect != "" && !flag.CommandLine.Changed("dialect") {
+			*flagDialect = selectedServer.Dialect
+		}
 	}
 
 	// Check for legal arguments
@@ -112,8 +148,12 @@ func mainWithCode() int {
 			_, _ = fmt.Fprintln(os.Stderr, "Invalid port provided")
 			illegalArguments = true
 		}
-		if *flagPassword == "" {
-			_, _ = fmt.Fprintln(os.Stderr, "Password not provided")
+		if err := checkProtocol(*flagProtocol); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Invalid protocol provided")
+			illegalArguments = true
+		}
+		if _, err := rcon.ParseDialect(*flagDialect); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Invalid dialect provided")
 			illegalArguments = true
 		}
 		if illegalArguments {
@@ -123,16 +163,66 @@ func mainWithCode() int {
 		}
 	}
 
+	if queryMode {
+		return queryMain(*flagHost, *flagPort, args[1:], logger)
+	}
+
+	// Read the password if it was not given directly, asking for it as a last resort
+	if *flagPassword == "" {
+		source := passwordSource{file: *flagPasswordFile, env: *flagPasswordEnv, command: *flagPasswordCommand}
+		password, err := source.read()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Could not read password:", err)
+			return -1
+		}
+		if password == "" {
+			password, _ = promptPassword()
+		}
+		if password == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Password not provided")
+			fmt.Println()
+			usage()
+			return -1
+		}
+		*flagPassword = password
+	}
+
 	// Create connection, handle failure, defer closure
-	conn, err := rcon.NewRCONConnection(*flagHost, *flagPort, *flagPassword)
+	dialect, _ := rcon.ParseDialect(*flagDialect)
+	options := []rcon.Option{rcon.WithDialect(dialect), rcon.WithLogger(logger)}
+	if *flagReconnect || proxyMode {
+		options = append(options, rcon.WithReconnect(rcon.ReconnectPolicy{
+			OnReconnect: func(event rcon.ReconnectEvent) {
+				if event.Err != nil {
+					_, _ = fmt.Fprintln(os.Stderr, "Reconnection attempt", event.Attempt, "failed:", event.Err)
+				} else {
+					_, _ = fmt.Fprintln(os.Stderr, "Reconnected after connection loss")
+				}
+			},
+		}))
+	}
+	if *flagAuditLog != "" {
+		address := net.JoinHostPort(*flagHost, strconv.Itoa(*flagPort))
+		auditLog, err := openAuditLog(*flagAuditLog, int64(*flagAuditLogMaxSize)<<20, *flagAuditLogBackups,
+			*flagServer, address)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Could not open audit log:", err)
+			return -1
+		}
+		defer auditLog.Close()
+		options = append(options, rcon.WithAuditHook(auditLog.hook))
+	}
+	conn, err := connect(*flagProtocol, *flagHost, *flagPort, *flagPassword, options)
 	if err != nil {
-		if connFailure, ok := err.(rcon.ConnectionFailure); ok {
+		var connFailure rcon.ConnectionFailure
+		var authFailure *rcon.AuthenticationFailure
+		if errors.As(err, &connFailure) {
 			_, err := fmt.Fprintln(os.Stderr, connFailure)
 			if err != nil {
 				panic(err)
 			}
 			return 2
-		} else if authFailure, ok := err.(rcon.AuthenticationFailure); ok {
+		} else if errors.As(err, &authFailure) {
 			_, err := fmt.Fprintln(os.Stderr, authFailure)
 			if err != nil {
 				panic(err)
@@ -144,10 +234,20 @@ func mainWithCode() int {
 	}
 	defer conn.Close()
 
+	if proxyMode {
+		return proxyMain(conn, proxy, *flagDebug)
+	}
+
 	// If command passed, just run it and be done
 	if len(args) != 0 {
 		cmd := strings.Join(args, " ")
 		result, err := conn.SendCommand(cmd)
+		// Some protocols only check the password when a command is sent
+		var authFailure *rcon.AuthenticationFailure
+		if errors.As(err, &authFailure) {
+			_, _ = fmt.Fprintln(os.Stderr, authFailure)
+			return 3
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -162,11 +262,27 @@ func mainWithCode() int {
 		scan = scanner.Scan()
 		result, err := conn.SendCommand(scanner.Text())
 		if err != nil {
-			if err == io.EOF {
+			var opErr *net.OpError
+			var connFailure rcon.ConnectionFailure
+			var authFailure *rcon.AuthenticationFailure
+			if errors.As(err, &authFailure) {
+				_, _ = fmt.Fprintln(os.Stderr, authFailure)
+				return 3
+			} else if errors.As(err, &connFailure) {
+				// Only reconnection can fail this way
+				fmt.Print(result)
+				_, _ = fmt.Fprintln(os.Stderr, connFailure)
+				return 2
+			} else if *flagReconnect && (errors.Is(err, io.EOF) || errors.As(err, &opErr)) {
+				// The connection will be re-established for the next command
+				fmt.Print(result)
+				_, _ = fmt.Fprintln(os.Stderr, "Connection lost:", err)
+				continue
+			} else if errors.Is(err, io.EOF) {
 				fmt.Print(result)
 				_, _ = fmt.Fprintln(os.Stderr, "Connection closed by remote host")
 				return 4
-			} else if opErr, ok := err.(*net.OpError); ok {
+			} else if errors.As(err, &opErr) {
 				fmt.Print(result)
 				_, _ = fmt.Fprintln(os.Stderr, opErr)
 				return 4
@@ -183,6 +299,26 @@ func mainWithCode() int {
 	return 0
 }
 
+// changedAny reports whether any of the named flags was set on the command line.
+func changedAny(names ...string) bool {
+	for _, name := range names {
+		if flag.CommandLine.Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// newLogger returns the logger passed to the rcon package, which writes everything down to debug level to standard
+// error if debug is set. Otherwise it returns nil, so that nothing is logged; errors are reported by the program
+// itself.
+func newLogger(debug bool) *slog.Logger {
+	if !debug {
+		return nil
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
 func main() {
 	os.Exit(mainWithCode())
 }
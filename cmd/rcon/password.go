@@ -0,0 +1,107 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// passwordSource tells where to read the password from when it is not given directly. At most one of its fields is
+// expected to be set.
+type passwordSource struct {
+	file    string
+	env     string
+	command string
+}
+
+// read returns the password from the configured source. It returns an empty string and no error if no source is set.
+// Errors never include the password itself.
+func (s passwordSource) read() (string, error) {
+	switch {
+	case s.file != "":
+		return passwordFromFile(s.file)
+	case s.env != "":
+		return passwordFromEnv(s.env)
+	case s.command != "":
+		return passwordFromCommand(s.command)
+	}
+	return "", nil
+}
+
+// passwordFromFile returns the first line of the file at path, so that a trailing newline is not taken as part of the
+// password.
+func passwordFromFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer clear(data)
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// passwordFromEnv returns the value of the environment variable name.
+func passwordFromEnv(name string) (string, error) {
+	password, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return password, nil
+}
+
+// passwordFromCommand runs command with the system shell and returns what it prints, without the trailing newline.
+// This allows the password to be kept in a password manager, for example with "pass show rcon/myserver".
+func passwordFromCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	defer clear(output)
+	if err != nil {
+		return "", fmt.Errorf("password command failed: %w", err)
+	}
+	return string(bytes.TrimRight(output, "\r\n")), nil
+}
+
+// promptPassword asks for the password on the terminal, without echoing it. It returns an error if standard input is
+// not a terminal.
+func promptPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("standard input is not a terminal")
+	}
+	_, _ = fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	defer clear(password)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/vibeisveryo/rcon"
+	"strings"
+)
+
+// protocolNames lists the protocols that can be selected with the --protocol option.
+var protocolNames = []string{"source", "battleye", "webrcon", "goldsrc"}
+
+// A connection is the part of the API shared by the connection types of every protocol.
+type connection interface {
+	SendCommand(cmd string) (string, error)
+	SendCommandContext(ctx context.Context, cmd string) (string, error)
+	Close()
+}
+
+// checkProtocol returns a non-nil error if protocol is not one of protocolNames.
+func checkProtocol(protocol string) error {
+	for _, name := range protocolNames {
+		if strings.ToLower(protocol) == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown protocol %q", protocol)
+}
+
+// connect opens a connection to the server, speaking the given protocol, which must have passed checkProtocol.
+func connect(protocol string, host string, port int, password string, options []rcon.Option) (connection, error) {
+	switch strings.ToLower(protocol) {
+	case "battleye":
+		return rcon.NewBattlEyeConnection(host, port, password, options...)
+	case "webrcon":
+		return rcon.NewWebRCONConnection(host, port, password, options...)
+	case "goldsrc":
+		return rcon.NewGoldSrcConnection(host, port, password, options...)
+	default:
+		return rcon.NewRCONConnection(host, port, password, options...)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultProxyListen is the address proxy mode listens on if the config file does not say.
+const defaultProxyListen = ":27016"
+
+// proxyConfig is the [proxy] table of the config file.
+type proxyConfig struct {
+	Listen string               `toml:"listen"`
+	Users  map[string]proxyUser `toml:"users"`
+}
+
+// proxyUser is the configuration of a single user of the proxy, under [proxy.users.name].
+type proxyUser struct {
+	Password        string `toml:"password"`
+	PasswordFile    string `toml:"password_file"`
+	PasswordEnv     string `toml:"password_env"`
+	PasswordCommand string `toml:"password_command"`
+
+	// Allow and Deny list the names of the commands the user may and may not run; see permits
+	Allow []string `toml:"allow"`
+	Deny  []string `toml:"deny"`
+}
+
+// proxyRequestKey is the key of the context value holding the *rcon.Request a command is forwarded for, so that the
+// audit log can tell which proxy user sent it.
+type proxyRequestKey struct{}
+
+// A proxy forwards the commands of its users to the server they may run.
+type proxy struct {
+	conn  connection
+	users map[string]proxyUser
+	// passwords holds the password of each user, by name
+	passwords map[string][]byte
+	log       *slog.Logger
+}
+
+// proxyMain runs proxy mode, accepting Source RCON clients on the address from the config file and forwarding their
+// commands over conn, until interrupted. It returns the exit code.
+func proxyMain(conn connection, config proxyConfig, debug bool) int {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	p, err := newProxy(conn, config, logger)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid proxy configuration:", err)
+		return -1
+	}
+	listen := config.Listen
+	if listen == "" {
+		listen = defaultProxyListen
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	server := rcon.NewServer(p.authenticate, p, rcon.WithLogger(logger))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	logger.Info("proxy listening", "address", listener.Addr(), "users", len(p.users))
+	err = server.Serve(listener)
+	if !errors.Is(err, rcon.ErrServerClosed) {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	logger.Info("proxy stopped")
+	return 0
+}
+
+// newProxy checks the configuration of the proxy, and reads the passwords of its users.
+func newProxy(conn connection, config proxyConfig, logger *slog.Logger) (*proxy, error) {
+	if len(config.Users) == 0 {
+		return nil, errors.New("no users configured")
+	}
+	p := &proxy{
+		conn:      conn,
+		users:     config.Users,
+		passwords: make(map[string][]byte),
+		log:       logger,
+	}
+	// Names are sorted so that errors are reported consistently
+	names := make([]string, 0, len(config.Users))
+	for name := range config.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	owners := make(map[string]string)
+	for _, name := range names {
+		user := config.Users[name]
+		password := user.Password
+		if password == "" {
+			source := passwordSource{file: user.PasswordFile, env: user.PasswordEnv, command: user.PasswordCommand}
+			var err error
+			password, err = source.read()
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", name, err)
+			}
+		}
+		if password == "" {
+			return nil, fmt.Errorf("user %s: password not provided", name)
+		}
+		// The password alone tells who a client is
+		if owner, ok := owners[password]; ok {
+			return nil, fmt.Errorf("users %s and %s have the same password", owner, name)
+		}
+		owners[password] = name
+		p.passwords[name] = []byte(password)
+		for _, pattern := range append(append([]string(nil), user.Allow...), user.Deny...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("user %s: invalid command pattern %q", name, pattern)
+			}
+		}
+	}
+	return p, nil
+}
+
+// authenticate identifies the user a client logs in as by the password it sends.
+func (p *proxy) authenticate(ctx context.Context, remote net.Addr, password string) (string, bool) {
+	// Every password is compared, in constant time, so that timing tells nothing about them
+	user, ok := "", false
+	for name, want := range p.passwords {
+		if subtle.ConstantTimeCompare([]byte(password), want) == 1 {
+			user, ok = name, true
+		}
+	}
+	if ok {
+		p.log.Info("login", "user", user, "remote", remote)
+	}
+	return user, ok
+}
+
+// ServeRCON forwards the command to the server if the user may run it, and logs the outcome.
+func (p *proxy) ServeRCON(ctx context.Context, req *rcon.Request) string {
+	user := p.users[req.User]
+	for _, cmd := range splitCommands(req.Command) {
+		name := commandName(cmd)
+		if !user.permits(name) {
+			p.log.Warn("deny command", "user", req.User, "remote", req.RemoteAddr, "command", req.Command,
+				"denied", name)
+			return fmt.Sprintf("Command %s is not permitted\n", name)
+		}
+	}
+	output, err := p.conn.SendCommandContext(context.WithValue(ctx, proxyRequestKey{}, req), req.Command)
+	if err != nil {
+		p.log.Error("forward command failed", "user", req.User, "remote", req.RemoteAddr, "command", req.Command,
+			"error", err)
+		return "Command failed: server unavailable\n"
+	}
+	p.log.Info("forward command", "user", req.User, "remote", req.RemoteAddr, "command", req.Command,
+		"output_size", len(output))
+	return output
+}
+
+// permits reports whether the user may run the command with the given name. Names are compared without regard to
+// case, and patterns may use the wildcards of path.Match. Deny rules take precedence over allow rules, and a command
+// matching neither is denied.
+func (u proxyUser) permits(name string) bool {
+	return !matchAny(u.Deny, name) && matchAny(u.Allow, name)
+}
+
+// matchAny reports whether name matches any of patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceBreaks are the characters the Source console tokenizer treats as words of their own, so that a command name
+// ends at any of them, as at whitespace.
+const sourceBreaks = "{}()':"
+
+// splitCommands splits a line into the commands a Source server runs for it: it runs each of the commands separated by
+// newlines, or by semicolons other than those within double quotes. Empty commands are left out.
+func splitCommands(line string) []string {
+	var commands []string
+	quoted := false
+	start := 0
+	for i := 0; i <= len(line); i++ {
+		if i < len(line) && line[i] == '"' {
+			quoted = !quoted
+		}
+		// A newline ends a command even within quotes
+		if i == len(line) || line[i] == '\n' || (!quoted && line[i] == ';') {
+			if cmd := strings.TrimSpace(line[start:i]); cmd != "" {
+				commands = append(commands, cmd)
+			}
+			start = i + 1
+			quoted = false
+		}
+	}
+	return commands
+}
+
+// commandName returns the name of a single command, in lower case, as the Source console tokenizer reads it: its first
+// word, which may be quoted, and otherwise ends at whitespace or any of sourceBreaks. A command starting with one of
+// sourceBreaks is named by that character alone. Control characters are taken for whitespace, so that the name is
+// never longer than the one the server reads.
+func commandName(cmd string) string {
+	start := 0
+	for start < len(cmd) && cmd[start] <= ' ' {
+		start++
+	}
+	cmd = cmd[start:]
+	if strings.HasPrefix(cmd, "\"") {
+		name, _, _ := strings.Cut(cmd[1:], "\"")
+		return strings.ToLower(name)
+	}
+	if cmd != "" && strings.IndexByte(sourceBreaks, cmd[0]) >= 0 {
+		return cmd[:1]
+	}
+	end := 0
+	for end < len(cmd) && cmd[end] > ' ' && strings.IndexByte(sourceBreaks, cmd[end]) < 0 {
+		end++
+	}
+	return strings.ToLower(cmd[:end])
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package main
+
+import (
+	"context"
+	"github.com/BurntSushi/toml"
+	"github.com/vibeisveryo/rcon"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingConnection is a connection which records the commands sent over it, answering each with "ok".
+type recordingConnection struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (c *recordingConnection) SendCommand(cmd string) (string, error) {
+	return c.SendCommandContext(context.Background(), cmd)
+}
+
+func (c *recordingConnection) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, cmd)
+	return "ok", nil
+}
+
+func (c *recordingConnection) Close() {}
+
+// testProxyConfig has a moderator who may run a few commands, and an admin who may run all but a few.
+var testProxyConfig = proxyConfig{Users: map[string]proxyUser{
+	"moderator": {Password: "modpassword", Allow: []string{"status", "kick", "say*"}},
+	"admin": {Password: "adminpassword", Allow: []string{"*"},
+		Deny: []string{"sv_cheats", "rcon_*", "Quit", "alias", "exec"}},
+}}
+
+// newTestProxy returns a proxy with testProxyConfig, forwarding commands to the returned connection.
+func newTestProxy(t *testing.T) (*proxy, *recordingConnection) {
+	conn := &recordingConnection{}
+	p, err := newProxy(conn, testProxyConfig, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Encountered error while creating proxy: %v", err)
+	}
+	return p, conn
+}
+
+func TestPermits(t *testing.T) {
+	cases := []struct {
+		user string
+		name string
+		want bool
+	}{
+		{"moderator", "status", true},
+		{"moderator", "kick", true},
+		{"moderator", "say", true},
+		{"moderator", "say_team", true},
+		{"moderator", "sv_cheats", false},
+		{"moderator", "ban", false},
+		{"moderator", "", false},
+		{"admin", "changelevel", true},
+		{"admin", "kick", true},
+		// Deny rules take precedence over allowing everything
+		{"admin", "sv_cheats", false},
+		{"admin", "rcon_password", false},
+		// Patterns are compared without regard to case
+		{"admin", "quit", false},
+		{"admin", "exec", false},
+	}
+	for _, c := range cases {
+		got := testProxyConfig.Users[c.user].permits(c.name)
+		if got != c.want {
+			t.Errorf("User %v, command %q, expected %v, got %v", c.user, c.name, c.want, got)
+		}
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"status", []string{"status"}},
+		{"say hi; sv_cheats 1", []string{"say hi", "sv_cheats 1"}},
+		{"say hi\nsv_cheats 1", []string{"say hi", "sv_cheats 1"}},
+		{"say \"a;b\"", []string{"say \"a;b\""}},
+		{"say \"a;b\"; sv_cheats 1", []string{"say \"a;b\"", "sv_cheats 1"}},
+		// A newline ends the command even within quotes, as does the end of an unterminated quote
+		{"say \"a\nsv_cheats 1", []string{"say \"a", "sv_cheats 1"}},
+		{"say \"a\nsv_cheats 1; quit", []string{"say \"a", "sv_cheats 1", "quit"}},
+		{";;status;\n", []string{"status"}},
+		{"", nil},
+	}
+	for _, c := range cases {
+		got := splitCommands(c.in)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Splitting %q, expected %q, got %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"status", "status"},
+		{"  SV_Cheats 1", "sv_cheats"},
+		{"sv_cheats\t1", "sv_cheats"},
+		{"\"sv_cheats\" 1", "sv_cheats"},
+		{"\"sv_cheats\"1", "sv_cheats"},
+		{"\"sv_cheats", "sv_cheats"},
+		{"sv_cheats:1", "sv_cheats"},
+		{"quit'", "quit"},
+		{"sv_cheats{1}", "sv_cheats"},
+		{"sv_cheats(1)", "sv_cheats"},
+		{"sv_cheats\x0b1", "sv_cheats"},
+		{"sv_cheats\x001", "sv_cheats"},
+		{"'quit", "'"},
+		{":", ":"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		got := commandName(c.in)
+		if got != c.want {
+			t.Errorf("Command %q, expected %q, got %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestProxyServeRCON(t *testing.T) {
+	cases := []struct {
+		user      string
+		command   string
+		forwarded bool
+	}{
+		{"moderator", "kick bob", true},
+		{"moderator", "say hi; kick bob", true},
+		{"moderator", "sv_cheats 1", false},
+		{"moderator", "say hi; sv_cheats 1", false},
+		{"moderator", "say hi\nsv_cheats 1", false},
+		{"moderator", "say \"hi\nsv_cheats 1", false},
+		{"admin", "changelevel cp_badlands", true},
+		{"admin", "say \"sv_cheats 1; quit\"", true},
+		{"admin", "sv_cheats:1", false},
+		{"admin", "quit'", false},
+		{"admin", "\tsv_cheats 1", false},
+		{"admin", "\"SV_CHEATS\" 1", false},
+		{"admin", "sv_cheats\t1", false},
+		{"admin", "status; rcon_password x", false},
+	}
+	for _, c := range cases {
+		p, conn := newTestProxy(t)
+		p.ServeRCON(context.Background(), &rcon.Request{Command: c.command, User: c.user})
+		var want []string
+		if c.forwarded {
+			want = []string{c.command}
+		}
+		if !reflect.DeepEqual(conn.commands, want) {
+			t.Errorf("User %v, command %q, expected %q forwarded, got %q", c.user, c.command, want, conn.commands)
+		}
+	}
+}
+
+func TestProxyAuthenticate(t *testing.T) {
+	t.Setenv("RCON_TEST_PROXY_PASSWORD", "envpassword")
+	config := proxyConfig{Users: map[string]proxyUser{
+		"moderator": {Password: "modpassword"},
+		"admin":     {PasswordEnv: "RCON_TEST_PROXY_PASSWORD"},
+	}}
+	p, err := newProxy(&recordingConnection{}, config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Encountered error while creating proxy: %v", err)
+	}
+	cases := []struct {
+		password string
+		user     string
+		ok       bool
+	}{
+		{"modpassword", "moderator", true},
+		{"envpassword", "admin", true},
+		{"modpasswor", "", false},
+		{"modpassword2", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		user, ok := p.authenticate(context.Background(), nil, c.password)
+		if user != c.user || ok != c.ok {
+			t.Errorf("Password %q, expected %q %v, got %q %v", c.password, c.user, c.ok, user, ok)
+		}
+	}
+}
+
+func TestNewProxyInvalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		users map[string]proxyUser
+	}{
+		{"no users", nil},
+		{"no password", map[string]proxyUser{"a": {Allow: []string{"*"}}}},
+		{"empty environment variable", map[string]proxyUser{"a": {PasswordEnv: "RCON_TEST_UNSET_VARIABLE"}}},
+		{"same password", map[string]proxyUser{"a": {Password: "same"}, "b": {Password: "same"}}},
+		{"invalid pattern", map[string]proxyUser{"a": {Password: "a", Deny: []string{"sv_[cheats"}}}},
+	}
+	for _, c := range cases {
+		_, err := newProxy(&recordingConnection{}, proxyConfig{Users: c.users},
+			slog.New(slog.NewTextHandler(io.Discard, nil)))
+		if err == nil {
+			t.Errorf("%v, expected error, got nil", c.name)
+		}
+	}
+}
+
+func TestDefaultProxyConfig(t *testing.T) {
+	// The commented example of the default config file, once uncommented, denies the commands which run others
+	_, example, ok := strings.Cut(defaultFileContent, "# [proxy]\n")
+	if !ok {
+		t.Fatalf("Expected proxy example in default config file")
+	}
+	var lines []string
+	for _, line := range strings.Split("# [proxy]\n"+example, "\n") {
+		lines = append(lines, strings.TrimPrefix(line, "# "))
+	}
+	var config struct {
+		Proxy proxyConfig `toml:"proxy"`
+	}
+	if _, err := toml.Decode(strings.Join(lines, "\n"), &config); err != nil {
+		t.Fatalf("Encountered error while decoding example: %v", err)
+	}
+	t.Setenv("SOMEADMIN_PROXY_PASSWORD", "adminpassword")
+	conn := &recordingConnection{}
+	p, err := newProxy(conn, config.Proxy, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Encountered error while creating proxy: %v", err)
+	}
+	cases := []struct {
+		user      string
+		command   string
+		forwarded bool
+	}{
+		{"somemoderator", "kick bob", true},
+		{"somemoderator", "sv_cheats 1", false},
+		{"someadmin", "changelevel cp_badlands", true},
+		{"someadmin", "sv_cheats 1", false},
+		{"someadmin", "alias x \"sv_cheats 1\"; x", false},
+		{"someadmin", "exec cheats.cfg", false},
+	}
+	for _, c := range cases {
+		conn.commands = nil
+		p.ServeRCON(context.Background(), &rcon.Request{Command: c.command, User: c.user})
+		if forwarded := len(conn.commands) > 0; forwarded != c.forwarded {
+			t.Errorf("User %v, command %q, expected forwarded %v, got %v", c.user, c.command, c.forwarded, forwarded)
+		}
+	}
+}
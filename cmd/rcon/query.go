@@ -0,0 +1,142 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/cheynewallace/tabby"
+	"github.com/vibeisveryo/rcon/a2s"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// queryNames lists what can be asked for in query mode, in the order it is printed.
+var queryNames = []string{"info", "players", "rules"}
+
+// queryMain runs query mode, printing what the server reports about itself over the Source query protocol, and
+// returns the exit code. queries lists which of queryNames to print; if it is empty, all of them are.
+func queryMain(host string, port int, queries []string, logger *slog.Logger) int {
+	selected := make(map[string]bool)
+	for _, query := range queries {
+		if !contains(queryNames, query) {
+			_, _ = fmt.Fprintln(os.Stderr, "Unknown query", query)
+			return -1
+		}
+		selected[query] = true
+	}
+	if len(selected) == 0 {
+		for _, query := range queryNames {
+			selected[query] = true
+		}
+	}
+
+	ctx := context.Background()
+	client, err := a2s.Dial(ctx, host, port, a2s.WithLogger(logger))
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	first := true
+	// section separates the output of each query with a blank line
+	section := func() {
+		if !first {
+			fmt.Println()
+		}
+		first = false
+	}
+	if selected["info"] {
+		info, err := client.Info(ctx)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Info query failed:", err)
+			return 2
+		}
+		section()
+		table := tabby.NewCustom(writer)
+		table.AddLine("Name", info.Name)
+		table.AddLine("Map", info.Map)
+		table.AddLine("Game", info.Game)
+		table.AddLine("Players", fmt.Sprintf("%v/%v (%v bots)", info.Players, info.MaxPlayers, info.Bots))
+		table.AddLine("Version", info.Version)
+		table.AddLine("Password", yesNo(info.Private))
+		table.AddLine("VAC", yesNo(info.VAC))
+		if info.Keywords != "" {
+			table.AddLine("Keywords", info.Keywords)
+		}
+		table.Print()
+	}
+	if selected["players"] {
+		players, err := client.Players(ctx)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Players query failed:", err)
+			return 2
+		}
+		section()
+		table := tabby.NewCustom(writer)
+		table.AddHeader("NAME", "SCORE", "TIME")
+		for _, player := range players {
+			table.AddLine(player.Name, strconv.Itoa(player.Score), player.Duration.Truncate(time.Second).String())
+		}
+		table.Print()
+	}
+	if selected["rules"] {
+		rules, err := client.Rules(ctx)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Rules query failed:", err)
+			return 2
+		}
+		section()
+		names := make([]string, 0, len(rules))
+		for name := range rules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		table := tabby.NewCustom(writer)
+		table.AddHeader("RULE", "VALUE")
+		for _, name := range names {
+			table.AddLine(name, rules[name])
+		}
+		table.Print()
+	}
+	return 0
+}
+
+// contains reports whether s is one of list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// yesNo formats b for humans.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
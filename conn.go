@@ -0,0 +1,111 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// A Conn sends and receives raw packets of the Source RCON protocol, leaving it to the caller to decide what to send
+// and what to make of the answers. It is the layer RCONConnection is built on, exposed for work the high-level API does
+// not cover, such as proxies, fuzzers and custom handshakes.
+//
+// A Conn should be created with DialConn or NewConn. It is safe for one goroutine to read packets while another writes
+// them; packets written concurrently are never interleaved on the wire.
+//
+// Of the options, those concerning dialing apply to DialConn, WithLogger and WithMaxPacketSize apply to all packets,
+// and WithDialect and WithCommandTimeout apply to Authenticate.
+type Conn struct {
+	client *client
+	opts   options
+
+	// readMu and writeMu serialise reads and writes respectively
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+}
+
+// DialConn connects to the server at the given host and port, and returns a Conn over the connection without sending
+// anything. It returns a ConnectionFailure if the server cannot be reached, and ctx.Err() if ctx is done first.
+func DialConn(ctx context.Context, host string, port int, opts ...Option) (*Conn, error) {
+	o, err := serverOptions(host, port, opts)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newClient(ctx, host, port, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{client: client, opts: o}, nil
+}
+
+// NewConn returns a Conn over a connection the caller has already established, such as one accepted by a proxy. The
+// Conn takes ownership of con and closes it when closed itself. Cancellation works as described on
+// NewRCONConnectionFromConn.
+func NewConn(con io.ReadWriteCloser, opts ...Option) *Conn {
+	o := newOptions(opts)
+	return &Conn{
+		client: &client{con: con, log: o.logger, maxSize: o.packetSizeLimit()},
+		opts:   o,
+	}
+}
+
+// Authenticate performs the authentication handshake of the Conn's dialect, as RCONConnection does on connecting. It
+// returns an AuthenticationFailure if the server rejects the password. No other packets may be read or written until
+// it returns.
+func (c *Conn) Authenticate(ctx context.Context, password string) error {
+	ctx, cancel := c.opts.commandContext(ctx)
+	defer cancel()
+	return c.opts.dialect.Authenticate(ctx, c, password)
+}
+
+// WritePacket sends p to the server. It returns ctx.Err() if ctx is done first. A packet cut off partway cannot be
+// recovered from, so the Conn should be closed after any error.
+func (c *Conn) WritePacket(ctx context.Context, p Packet) error {
+	return c.WritePackets(ctx, p)
+}
+
+// WritePackets is like WritePacket, but sends any number of packets in a single write, in the order given.
+func (c *Conn) WritePackets(ctx context.Context, ps ...Packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.client.withWriteContext(ctx, func() error {
+		return c.client.sendPackets(ps...)
+	})
+}
+
+// ReadPacket waits for the next packet from the server and returns it. It returns ctx.Err() if ctx is done first, and
+// a *PacketSizeError if the packet is larger than allowed by WithMaxPacketSize. If ctx is done, or the packet is
+// rejected, partway through a packet, the rest of the stream cannot be made sense of, so the Conn should be closed
+// after any error.
+func (c *Conn) ReadPacket(ctx context.Context) (Packet, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	var p Packet
+	err := c.client.withReadContext(ctx, func() (err error) {
+		p, err = c.client.receivePacket()
+		return err
+	})
+	return p, err
+}
+
+// Close closes the underlying connection. Reads and writes in progress on other goroutines return with an error.
+func (c *Conn) Close() error {
+	c.client.logger().Debug("close connection")
+	return c.client.con.Close()
+}
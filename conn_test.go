@@ -0,0 +1,105 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketEncodeDecode(t *testing.T) {
+	cases := []Packet{
+		{0, ServerdataAuth, testPassword},
+		{-1, ServerdataAuthResponse, ""},
+		{42, ServerdataResponseValue, "\x00\x01\x00\x00"},
+	}
+	var buf bytes.Buffer
+	for _, c := range cases {
+		if err := c.Encode(&buf); err != nil {
+			t.Fatalf("Encountered error while encoding %+v: %v", c, err)
+		}
+	}
+	for _, c := range cases {
+		var got Packet
+		if err := got.Decode(&buf); err != nil {
+			t.Fatalf("Encountered error while decoding %+v: %v", c, err)
+		}
+		if got != c {
+			t.Errorf("Decoding, expected %+v, got %+v", c, got)
+		}
+	}
+
+	var p Packet
+	err := p.Decode(bytes.NewReader(binary.LittleEndian.AppendUint32(nil, defaultMaxPacketSize+1)))
+	var sizeErr *PacketSizeError
+	if !errors.As(err, &sizeErr) {
+		t.Errorf("Decoding oversized packet, expected packet size error, got %v", err)
+	}
+}
+
+func TestConn(t *testing.T) {
+	ctx := context.Background()
+	serverCon, clientCon := net.Pipe()
+	go serveEcho(serverCon)
+	conn := NewConn(clientCon)
+	defer conn.Close()
+	if err := conn.Authenticate(ctx, testPassword); err != nil {
+		t.Fatalf("Encountered error while authenticating: %v", err)
+	}
+
+	// A command followed by the empty packet which SRCDS mirrors, then answers
+	err := conn.WritePackets(ctx, Packet{7, ServerdataExeccommand, "echo raw"}, Packet{8, ServerdataResponseValue, ""})
+	if err != nil {
+		t.Fatalf("Encountered error while writing packets: %v", err)
+	}
+	want := []Packet{
+		{7, ServerdataResponseValue, "raw"},
+		{8, ServerdataResponseValue, ""},
+		{8, ServerdataResponseValue, "\x00\x01\x00\x00"},
+	}
+	for _, w := range want {
+		got, err := conn.ReadPacket(ctx)
+		if err != nil {
+			t.Fatalf("Encountered error while reading packet: %v", err)
+		}
+		if got != w {
+			t.Errorf("Reading packet, expected %+v, got %+v", w, got)
+		}
+	}
+
+	// Nothing more arrives
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.ReadPacket(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Reading with nothing sent, expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestConnAuthenticationFailure(t *testing.T) {
+	serverCon, clientCon := net.Pipe()
+	go serveEcho(serverCon)
+	conn := NewConn(clientCon)
+	defer conn.Close()
+	var authFailure *AuthenticationFailure
+	if err := conn.Authenticate(context.Background(), "wrong"); !errors.As(err, &authFailure) {
+		t.Errorf("Expected authentication failure, got %v", err)
+	}
+}
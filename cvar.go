@@ -0,0 +1,190 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Cvar is a console variable of a Source server, as returned by GetCvar and GetCvars.
+type Cvar struct {
+	Name  string
+	Value string
+	// Default is the value the cvar has when not set; servers leave it out when the cvar has its default value, in
+	// which case it is the same as Value
+	Default string
+	// Flags are the cvar's flags, such as "notify", "replicated" or "cheat"
+	Flags       []string
+	Description string
+}
+
+// Int returns the value of the cvar as an integer.
+func (c Cvar) Int() (int, error) {
+	return strconv.Atoi(c.Value)
+}
+
+// Float returns the value of the cvar as a floating-point number.
+func (c Cvar) Float() (float64, error) {
+	return strconv.ParseFloat(c.Value, 64)
+}
+
+// Bool returns the value of the cvar as a boolean, which Source servers hold as a number which is 0 if false.
+func (c Cvar) Bool() (bool, error) {
+	f, err := c.Float()
+	return f != 0, err
+}
+
+// cvarPattern matches the first line of the description of a cvar, capturing its name, value, default value if given,
+// and the rest of the line, which holds flags and possibly the start of the description.
+var cvarPattern = regexp.MustCompile(`(?m)^"([^"]+)" = "([^"]*)"(?: \( def\. "([^"]*)" \))?(.*)$`)
+
+// GetCvar returns the cvar with the given name, which is compared without regard to case, as servers do. It returns a
+// non-nil error if the server has no such cvar. It only works with Source servers.
+func (conn *RCONConnection) GetCvar(ctx context.Context, name string) (Cvar, error) {
+	cvars, err := conn.GetCvars(ctx, name)
+	if err != nil {
+		return Cvar{}, err
+	}
+	return cvars[name], nil
+}
+
+// GetCvars returns the cvars with the given names, keyed by the names as given, asking for all of them with a single
+// command. Names are compared without regard to case, as servers do. It returns a non-nil error if the server lacks any
+// of them.
+func (conn *RCONConnection) GetCvars(ctx context.Context, names ...string) (map[string]Cvar, error) {
+	for _, name := range names {
+		if err := checkCvarName(name); err != nil {
+			return nil, err
+		}
+	}
+	output, err := conn.SendCommandContext(ctx, strings.Join(names, ";"))
+	if err != nil {
+		return nil, err
+	}
+	parsed := parseCvars(output)
+	cvars := make(map[string]Cvar, len(names))
+	for _, name := range names {
+		cvar, ok := parsed[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cvar %q", name)
+		}
+		cvars[name] = cvar
+	}
+	return cvars, nil
+}
+
+// SetCvar sets the cvar with the given name to value, then checks that the server took the value, in the same command.
+// It returns a non-nil error if the server has no such cvar, or if the cvar does not hold value afterwards, as happens
+// when the value is out of the cvar's bounds or the cvar cannot be changed.
+func (conn *RCONConnection) SetCvar(ctx context.Context, name string, value string) error {
+	if err := checkCvarName(name); err != nil {
+		return err
+	}
+	if strings.ContainsAny(value, "\"\n") {
+		return errors.New("cannot have quotes or newlines in cvar value")
+	}
+	output, err := conn.SendCommandContext(ctx, fmt.Sprintf("%s \"%s\";%s", name, value, name))
+	if err != nil {
+		return err
+	}
+	cvar, ok := parseCvars(output)[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown cvar %q", name)
+	}
+	if !sameCvarValue(cvar.Value, value) {
+		return fmt.Errorf("cvar %q is %q after setting it to %q", name, cvar.Value, value)
+	}
+	return nil
+}
+
+// checkCvarName returns a non-nil error if name could not be sent as a single word of a command.
+func checkCvarName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n\";") {
+		return fmt.Errorf("invalid cvar name %q", name)
+	}
+	return nil
+}
+
+// sameCvarValue reports whether the value a server reports for a cvar is the value it was set to. Servers may format
+// numbers differently from how they were given, so numbers are compared by value.
+func sameCvarValue(got string, want string) bool {
+	if got == want {
+		return true
+	}
+	gotFloat, err := strconv.ParseFloat(got, 64)
+	if err != nil {
+		return false
+	}
+	wantFloat, err := strconv.ParseFloat(want, 64)
+	return err == nil && gotFloat == wantFloat
+}
+
+// parseCvars parses the descriptions of any number of cvars, as printed by a Source server when given a cvar name
+// without a value, such as
+//
+//	"sv_gravity" = "600" ( def. "800" ) notify replicated
+//	 - World gravity.
+//
+// Some games print the description on the first line, and some print bounds between the flags and the description.
+// Output other than cvar descriptions, such as the complaints of the server about unknown commands, is ignored. The
+// cvars are keyed by their names in lower case, since servers compare names without regard to case.
+func parseCvars(output string) map[string]Cvar {
+	cvars := make(map[string]Cvar)
+	matches := cvarPattern.FindAllStringSubmatchIndex(output, -1)
+	for i, m := range matches {
+		cvar := Cvar{
+			Name:    output[m[2]:m[3]],
+			Value:   output[m[4]:m[5]],
+			Default: output[m[4]:m[5]],
+		}
+		if m[6] >= 0 {
+			cvar.Default = output[m[6]:m[7]]
+		}
+		// The cvar's description runs until the next one starts
+		end := len(output)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		var lines []string
+		for _, line := range strings.Split(output[m[8]:end], "\n") {
+			if !strings.HasPrefix(line, "Unknown command") {
+				lines = append(lines, line)
+			}
+		}
+		rest := strings.TrimSpace(strings.Join(lines, "\n"))
+		flags, description, _ := strings.Cut(rest, " - ")
+		if strings.HasPrefix(rest, "- ") {
+			flags, description = "", rest[len("- "):]
+		}
+		fields := strings.Fields(flags)
+		for j := 0; j < len(fields); j++ {
+			// Bounds read "min. 0.000000 max. 1.000000"
+			if fields[j] == "min." || fields[j] == "max." {
+				j++
+				continue
+			}
+			cvar.Flags = append(cvar.Flags, fields[j])
+		}
+		cvar.Description = strings.TrimSpace(description)
+		cvars[strings.ToLower(cvar.Name)] = cvar
+	}
+	return cvars
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newCvarConnection returns an RCONConnection talking to a server with the cvars sv_gravity, mp_timelimit and
+// sv_cheats, the latter of which cannot be changed, over an in-memory pipe. Like a real server, it takes names in any
+// case, and prints them as they were registered.
+func newCvarConnection(t *testing.T) *RCONConnection {
+	var mu sync.Mutex
+	values := map[string]string{"sv_gravity": "800", "mp_timelimit": "0", "sv_cheats": "0"}
+	handle := func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		var output strings.Builder
+		for _, statement := range strings.Split(cmd, ";") {
+			name, value, set := strings.Cut(strings.TrimSpace(statement), " ")
+			name = strings.ToLower(name)
+			if _, ok := values[name]; !ok {
+				_, _ = fmt.Fprintf(&output, "Unknown command \"%s\"\n", name)
+				continue
+			}
+			if set {
+				if name != "sv_cheats" {
+					values[name] = strings.Trim(value, "\"")
+				}
+				continue
+			}
+			switch name {
+			case "sv_gravity":
+				_, _ = fmt.Fprintf(&output, "\"sv_gravity\" = \"%s\" ( def. \"800\" ) notify replicated\n"+
+					" - World gravity.\n", values[name])
+			case "mp_timelimit":
+				_, _ = fmt.Fprintf(&output, "\"mp_timelimit\" = \"%s\" ( def. \"0\" ) min. 0.000000 game notify "+
+					"replicated - game time per map in minutes\n", values[name])
+			case "sv_cheats":
+				_, _ = fmt.Fprintf(&output, "\"sv_cheats\" = \"%s\"\n notify replicated\n"+
+					" - Allow cheats on server\n", values[name])
+			}
+		}
+		return output.String()
+	}
+
+	serverCon, clientCon := net.Pipe()
+	go serve(serverCon, handle)
+	conn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword)
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestParseCvars(t *testing.T) {
+	output := "\"sv_gravity\" = \"600\" ( def. \"800\" ) notify replicated\n - World gravity.\n" +
+		"Unknown command \"sv_nonexistent\"\n" +
+		"\"mp_friendlyfire\" = \"0\" game notify replicated - Allows team members to injure other members\n" +
+		"\"mp_timelimit\" = \"30\" ( def. \"0\" ) min. 0.000000 max. 600.000000 game notify\n" +
+		"\"Cl_ShowFPS\" = \"0\"\n"
+	want := map[string]Cvar{
+		"sv_gravity": {Name: "sv_gravity", Value: "600", Default: "800", Flags: []string{"notify", "replicated"},
+			Description: "World gravity."},
+		"mp_friendlyfire": {Name: "mp_friendlyfire", Value: "0", Default: "0",
+			Flags: []string{"game", "notify", "replicated"}, Description: "Allows team members to injure other members"},
+		"mp_timelimit": {Name: "mp_timelimit", Value: "30", Default: "0", Flags: []string{"game", "notify"}},
+		"cl_showfps":   {Name: "Cl_ShowFPS", Value: "0", Default: "0"},
+	}
+	got := parseCvars(output)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected cvars %+v, got %+v", want, got)
+	}
+}
+
+func TestGetCvars(t *testing.T) {
+	conn := newCvarConnection(t)
+	cvars, err := conn.GetCvars(context.Background(), "sv_gravity", "mp_timelimit", "sv_cheats")
+	if err != nil {
+		t.Fatalf("Encountered error while getting cvars: %v", err)
+	}
+	for name, want := range map[string]string{"sv_gravity": "800", "mp_timelimit": "0", "sv_cheats": "0"} {
+		if cvars[name].Value != want {
+			t.Errorf("Cvar %v, expected value %q, got %q", name, want, cvars[name].Value)
+		}
+	}
+	if cheats, _ := cvars["sv_cheats"].Bool(); cheats {
+		t.Errorf("Expected sv_cheats to be false")
+	}
+
+	// Names are compared without regard to case, and the cvars are keyed by the names as given
+	cvars, err = conn.GetCvars(context.Background(), "SV_GRAVITY", "Mp_TimeLimit")
+	if err != nil {
+		t.Fatalf("Encountered error while getting cvars: %v", err)
+	}
+	for name, want := range map[string]string{"SV_GRAVITY": "sv_gravity", "Mp_TimeLimit": "mp_timelimit"} {
+		if cvars[name].Name != want {
+			t.Errorf("Cvar %v, expected name %q, got %q", name, want, cvars[name].Name)
+		}
+	}
+	if gravity, err := conn.GetCvar(context.Background(), "Sv_Gravity"); err != nil || gravity.Value != "800" {
+		t.Errorf("Expected Sv_Gravity 800, got %+v, error %v", gravity, err)
+	}
+
+	if _, err := conn.GetCvars(context.Background(), "sv_gravity", "sv_nonexistent"); err == nil {
+		t.Errorf("Expected error getting unknown cvar")
+	}
+	if _, err := conn.GetCvar(context.Background(), "sv_gravity; quit"); err == nil {
+		t.Errorf("Expected error getting cvar with invalid name")
+	}
+}
+
+func TestSetCvar(t *testing.T) {
+	conn := newCvarConnection(t)
+	cases := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"sv_gravity", "600", false},
+		{"mp_timelimit", "30.0", false},
+		{"MP_TIMELIMIT", "20", false},
+		{"sv_cheats", "1", true},
+		{"sv_nonexistent", "1", true},
+		{"sv_gravity", "\"", true},
+	}
+	for _, c := range cases {
+		err := conn.SetCvar(context.Background(), c.name, c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Setting %v to %q, expected error %v, got %v", c.name, c.value, c.wantErr, err)
+		}
+	}
+	gravity, err := conn.GetCvar(context.Background(), "sv_gravity")
+	if err != nil {
+		t.Fatalf("Encountered error while getting cvar: %v", err)
+	}
+	if n, _ := gravity.Int(); n != 600 || gravity.Default != "800" {
+		t.Errorf("Expected sv_gravity 600 with default 800, got %+v", gravity)
+	}
+}
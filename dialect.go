@@ -0,0 +1,267 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A Dialect is a variant of the Source RCON protocol, as spoken by a particular family of servers. Servers other than
+// Source engine ones implement the protocol with small but incompatible differences, chiefly in the order of packets
+// during authentication and in how the end of a multiple-packet response can be detected. A Dialect encapsulates
+// these differences; choose one with WithDialect.
+//
+// Servers which differ in ways none of the built-in dialects cover can be supported by implementing Dialect outside
+// this package. Commands sent concurrently over one connection call SendCommand concurrently, each with a Call of its
+// own, so implementations must be safe for concurrent use.
+type Dialect interface {
+	// Name returns the name of the dialect; those of the built-in dialects are accepted by ParseDialect.
+	Name() string
+
+	// Authenticate performs the handshake over a freshly opened conn, by reading and writing packets on it. It returns
+	// an AuthenticationFailure if the server rejects the password. It must not call conn.Authenticate.
+	Authenticate(ctx context.Context, conn *Conn, password string) error
+	// SendCommand sends cmd over sess, and returns the complete response once it can tell it has arrived, along with
+	// whatever output had been received if it fails.
+	SendCommand(ctx context.Context, sess *Session, cmd string) (string, error)
+	// Ping checks that the server is still responding on sess.
+	Ping(ctx context.Context, sess *Session) error
+	// MaxPacketSize returns the largest size field accepted from the server by default, or 0 for the default of
+	// Source servers.
+	MaxPacketSize() int
+}
+
+var (
+	// DialectSRCDS is the protocol as implemented by Source engine dedicated servers; it is the default. The end of a
+	// response is detected by sending an empty SERVERDATA_RESPONSE_VALUE packet after the command, which these
+	// servers mirror.
+	DialectSRCDS Dialect = srcdsDialect{}
+
+	// DialectMinecraft is the protocol as implemented by Minecraft: Java Edition servers. These send no empty packet
+	// ahead of the authentication response, do not mirror SERVERDATA_RESPONSE_VALUE packets, split responses into
+	// packets of up to 4096 bytes, and accept commands of up to 1446 bytes. The end of a response is detected by
+	// sending a packet of an unsupported type after the command, which these servers answer with an error.
+	DialectMinecraft Dialect = minecraftDialect{}
+
+	// DialectFactorio is the protocol as implemented by Factorio servers, which answer every command with exactly one
+	// packet, however long, and ignore packets of any other type.
+	DialectFactorio Dialect = singleResponseDialect{"factorio"}
+
+	// DialectARK is the protocol as implemented by ARK: Survival Evolved servers, which answer every command with
+	// exactly one packet, however long, and may or may not send an empty packet ahead of the authentication response.
+	DialectARK Dialect = singleResponseDialect{"ark"}
+
+	// DialectPalworld is the protocol as implemented by Palworld servers, which answer every command with exactly one
+	// packet and do not reliably answer packets of any other type.
+	DialectPalworld Dialect = singleResponseDialect{"palworld"}
+)
+
+// dialects lists the built-in dialects, for lookup by name.
+var dialects = []Dialect{DialectSRCDS, DialectMinecraft, DialectFactorio, DialectARK, DialectPalworld}
+
+// minecraftMaxCommandLength is the longest command body, in bytes, that a Minecraft server accepts.
+const minecraftMaxCommandLength = 1446
+
+// ParseDialect returns the built-in dialect with the given name, ignoring case; "source" is accepted as an alias of
+// "srcds".
+func ParseDialect(name string) (Dialect, error) {
+	name = strings.ToLower(name)
+	if name == "source" {
+		return DialectSRCDS, nil
+	}
+	for _, d := range dialects {
+		if d.Name() == name {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown dialect %q", name)
+}
+
+// DialectNames returns the names of the built-in dialects.
+func DialectNames() []string {
+	names := make([]string, len(dialects))
+	for i, d := range dialects {
+		names[i] = d.Name()
+	}
+	return names
+}
+
+// WithDialect sets the protocol dialect spoken by the server; the default is DialectSRCDS.
+func WithDialect(d Dialect) Option {
+	return func(o *options) {
+		if d != nil {
+			o.dialect = d
+		}
+	}
+}
+
+/**
+ * Source
+ */
+
+// srcdsDialect implements DialectSRCDS.
+type srcdsDialect struct{}
+
+func (srcdsDialect) Name() string {
+	return "srcds"
+}
+
+func (srcdsDialect) Authenticate(ctx context.Context, conn *Conn, password string) error {
+	return authenticate(ctx, conn, password, true)
+}
+
+func (srcdsDialect) SendCommand(ctx context.Context, sess *Session, cmd string) (string, error) {
+	return sendCommandSRCDS(ctx, sess, cmd)
+}
+
+func (srcdsDialect) MaxPacketSize() int {
+	return defaultMaxPacketSize
+}
+
+func (srcdsDialect) Ping(ctx context.Context, sess *Session) error {
+	c, err := sess.StartCall(1)
+	if err != nil {
+		return err
+	}
+	defer c.End()
+	return check(ctx, c, c.IDs()[0])
+}
+
+/**
+ * Minecraft
+ */
+
+// minecraftDialect implements DialectMinecraft.
+type minecraftDialect struct{}
+
+func (minecraftDialect) Name() string {
+	return "minecraft"
+}
+
+func (minecraftDialect) Authenticate(ctx context.Context, conn *Conn, password string) error {
+	return authenticate(ctx, conn, password, false)
+}
+
+// SendCommand follows the command with a SERVERDATA_RESPONSE_VALUE packet, which Minecraft servers answer with an
+// "Unknown request" error; this marks the end of the response.
+func (minecraftDialect) SendCommand(ctx context.Context, sess *Session, cmd string) (string, error) {
+	if len(cmd) > minecraftMaxCommandLength {
+		return "", fmt.Errorf("command too long; Minecraft accepts at most %v bytes", minecraftMaxCommandLength)
+	}
+
+	// IDs for the request and end marker packets, in that order
+	c, err := sess.StartCall(2)
+	if err != nil {
+		return "", err
+	}
+	defer c.End()
+	ids := c.IDs()
+	requestId, endId := ids[0], ids[1]
+
+	err = c.Send(ctx, Packet{ID: requestId, Type: ServerdataExeccommand, Body: cmd},
+		Packet{ID: endId, Type: ServerdataResponseValue, Body: ""})
+	if err != nil {
+		return "", err
+	}
+
+	// Responses longer than 4096 bytes arrive split over several packets, all before the end marker's response
+	var respBody strings.Builder
+	for {
+		resp, err := c.Receive(ctx)
+		if err != nil {
+			return respBody.String(), err
+		}
+		if resp.ID == endId {
+			return respBody.String(), nil
+		}
+		err = expectType("response", ServerdataResponseValue, resp)
+		if err != nil {
+			return respBody.String(), err
+		}
+		respBody.WriteString(resp.Body)
+	}
+}
+
+func (minecraftDialect) MaxPacketSize() int {
+	return defaultMaxPacketSize
+}
+
+// Ping accepts any response to a check packet, since Minecraft servers answer it with an error rather than mirroring
+// it.
+func (minecraftDialect) Ping(ctx context.Context, sess *Session) error {
+	c, err := sess.StartCall(1)
+	if err != nil {
+		return err
+	}
+	defer c.End()
+	err = c.Send(ctx, Packet{ID: c.IDs()[0], Type: ServerdataResponseValue, Body: ""})
+	if err != nil {
+		return err
+	}
+	_, err = c.Receive(ctx)
+	return err
+}
+
+/**
+ * Factorio, ARK and Palworld
+ */
+
+// singleResponseDialect implements the dialects of servers which answer each command with exactly one packet.
+type singleResponseDialect struct {
+	name string
+}
+
+func (d singleResponseDialect) Name() string {
+	return d.name
+}
+
+func (singleResponseDialect) Authenticate(ctx context.Context, conn *Conn, password string) error {
+	return authenticate(ctx, conn, password, false)
+}
+
+func (singleResponseDialect) SendCommand(ctx context.Context, sess *Session, cmd string) (string, error) {
+	c, err := sess.StartCall(1)
+	if err != nil {
+		return "", err
+	}
+	defer c.End()
+	err = c.Send(ctx, Packet{ID: c.IDs()[0], Type: ServerdataExeccommand, Body: cmd})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.Receive(ctx)
+	if err != nil {
+		return "", err
+	}
+	err = expectType("response", ServerdataResponseValue, resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.Body, nil
+}
+
+func (singleResponseDialect) MaxPacketSize() int {
+	return singleResponseMaxPacketSize
+}
+
+// Ping sends an empty command, since these servers cannot be relied on to answer anything else.
+func (d singleResponseDialect) Ping(ctx context.Context, sess *Session) error {
+	_, err := d.SendCommand(ctx, sess, "")
+	return err
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// serveMinecraft answers authentication and commands sent over con the way a Minecraft server does, replying to
+// "repeat n" with n bytes of output split into packets of at most 4096 bytes. It returns once con is closed.
+func serveMinecraft(con net.Conn) {
+	server := client{con: con}
+	for {
+		p, err := server.receivePacket()
+		if err != nil {
+			return
+		}
+		var replies []Packet
+		switch p.Type {
+		case ServerdataAuth:
+			authId := p.ID
+			if p.Body != testPassword {
+				authId = -1
+			}
+			replies = append(replies, Packet{authId, ServerdataAuthResponse, ""})
+		case ServerdataExeccommand:
+			var n int
+			_, _ = fmt.Sscanf(p.Body, "repeat %d", &n)
+			body := strings.Repeat("a", n)
+			for len(body) > 4096 {
+				replies = append(replies, Packet{p.ID, ServerdataResponseValue, body[:4096]})
+				body = body[4096:]
+			}
+			replies = append(replies, Packet{p.ID, ServerdataResponseValue, body})
+		default:
+			replies = append(replies, Packet{p.ID, ServerdataResponseValue, fmt.Sprintf("Unknown request %x", p.Type)})
+		}
+		for _, r := range replies {
+			if server.sendPacket(r) != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestMinecraftDialect(t *testing.T) {
+	serverCon, clientCon := net.Pipe()
+	go serveMinecraft(serverCon)
+	conn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword, WithDialect(DialectMinecraft))
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+
+	for _, n := range []int{0, 10, 4096, 10000} {
+		got, err := conn.SendCommand(fmt.Sprintf("repeat %d", n))
+		if err != nil {
+			t.Errorf("Encountered error while sending command for %v bytes: %v", n, err)
+		}
+		if got != strings.Repeat("a", n) {
+			t.Errorf("Expected %v bytes of response, got %v", n, len(got))
+		}
+	}
+	if err := conn.Ping(context.Background()); err != nil {
+		t.Errorf("Encountered error while pinging: %v", err)
+	}
+	if _, err := conn.SendCommand(strings.Repeat("a", 1447)); err == nil {
+		t.Errorf("Expected error sending command longer than Minecraft accepts")
+	}
+}
+
+func TestSingleResponseDialects(t *testing.T) {
+	// serveEcho sends an empty packet ahead of the authentication response and serveMinecraft does not; both answer
+	// commands with a single packet
+	servers := []struct {
+		serve func(net.Conn)
+		cmd   string
+	}{
+		{serveEcho, "echo aaaaaaaaaa"},
+		{serveMinecraft, "repeat 10"},
+	}
+	for _, d := range []Dialect{DialectFactorio, DialectARK, DialectPalworld} {
+		for _, server := range servers {
+			serverCon, clientCon := net.Pipe()
+			go server.serve(serverCon)
+			conn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword, WithDialect(d))
+			if err != nil {
+				t.Errorf("Dialect %v, encountered error while connecting: %v", d.Name(), err)
+				continue
+			}
+			got, err := conn.SendCommand(server.cmd)
+			if err != nil {
+				t.Errorf("Dialect %v, encountered error while sending command %q: %v", d.Name(), server.cmd, err)
+			}
+			if got != "aaaaaaaaaa" {
+				t.Errorf("Dialect %v, command %q, expected response %q, got %q", d.Name(), server.cmd, "aaaaaaaaaa", got)
+			}
+			if err := conn.Ping(context.Background()); err != nil {
+				t.Errorf("Dialect %v, encountered error while pinging: %v", d.Name(), err)
+			}
+			conn.Close()
+		}
+	}
+}
+
+func TestParseDialect(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Dialect
+	}{
+		{"srcds", DialectSRCDS},
+		{"Source", DialectSRCDS},
+		{"minecraft", DialectMinecraft},
+		{"Factorio", DialectFactorio},
+		{"ark", DialectARK},
+		{"palworld", DialectPalworld},
+	}
+	for _, c := range cases {
+		got, err := ParseDialect(c.in)
+		if err != nil {
+			t.Errorf("Encountered error while parsing dialect %q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Dialect %q, expected %v, got %v", c.in, c.want, got)
+		}
+	}
+	if _, err := ParseDialect("quake"); err == nil {
+		t.Errorf("Expected error parsing unknown dialect")
+	}
+}
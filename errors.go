@@ -0,0 +1,102 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported for malformed packets, which may be wrapped with more detail; they should be checked for with
+// errors.Is.
+var (
+	// ErrPacketTruncated is reported for a packet too short to hold the fields every packet has
+	ErrPacketTruncated = errors.New("invalid data - too short")
+	// ErrPacketOversized is reported for a packet whose size field exceeds the largest size accepted
+	ErrPacketOversized = errors.New("invalid data - too large")
+	// ErrPacketNotTerminated is reported for a packet whose body or whole is not terminated by a zero byte
+	ErrPacketNotTerminated = errors.New("invalid data - not zero-terminated")
+)
+
+// A PacketSizeError reports a packet whose size field is out of the accepted range, before anything else of the packet
+// is read. It wraps ErrPacketTruncated or ErrPacketOversized, and should be checked for with errors.As to learn the
+// sizes involved.
+type PacketSizeError struct {
+	// Size is the size field received, which may be negative
+	Size int
+	// Min and Max are the smallest and largest sizes accepted
+	Min int
+	Max int
+}
+
+func (e *PacketSizeError) Error() string {
+	if e.Size < e.Min {
+		return fmt.Sprintf("%v; packet size %v is below minimum of %v", ErrPacketTruncated, e.Size, e.Min)
+	}
+	return fmt.Sprintf("%v; packet size %v exceeds limit of %v", ErrPacketOversized, e.Size, e.Max)
+}
+
+func (e *PacketSizeError) Unwrap() error {
+	if e.Size < e.Min {
+		return ErrPacketTruncated
+	}
+	return ErrPacketOversized
+}
+
+// A ProtocolError reports that the server sent a well-formed packet other than the one the protocol calls for at that
+// point in the exchange, as happens with servers which implement the protocol differently from what the dialect of the
+// connection expects. It should be checked for with errors.As.
+type ProtocolError struct {
+	// Stage names the point in the exchange, such as "auth response" or "ping"
+	Stage string
+	// The ID, type and body of the packet expected; those which are not checked at this stage are copied from the
+	// packet received
+	ExpectedID   int
+	ExpectedType PacketType
+	ExpectedBody string
+	// The ID, type and body of the packet received
+	ReceivedID   int
+	ReceivedType PacketType
+	ReceivedBody string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("received unexpected packet (%v); expected %v %v %q, got %v %v %q", e.Stage, e.ExpectedID,
+		e.ExpectedType, e.ExpectedBody, e.ReceivedID, e.ReceivedType, e.ReceivedBody)
+}
+
+// expectPacket returns a *ProtocolError for stage if got differs from want, and nil otherwise.
+func expectPacket(stage string, want Packet, got Packet) error {
+	if got == want {
+		return nil
+	}
+	return &ProtocolError{
+		Stage:        stage,
+		ExpectedID:   want.ID,
+		ExpectedType: want.Type,
+		ExpectedBody: want.Body,
+		ReceivedID:   got.ID,
+		ReceivedType: got.Type,
+		ReceivedBody: got.Body,
+	}
+}
+
+// expectType is like expectPacket, but only checks the type of got.
+func expectType(stage string, wantType PacketType, got Packet) error {
+	want := got
+	want.Type = wantType
+	return expectPacket(stage, want, got)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPacketErrors(t *testing.T) {
+	cases := []struct {
+		in   []byte
+		want error
+	}{
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0, 0}, ErrPacketTruncated},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, ErrPacketNotTerminated},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0}, ErrPacketNotTerminated},
+	}
+	for _, c := range cases {
+		_, err := deserializePacket(c.in)
+		if !errors.Is(err, c.want) {
+			t.Errorf("Deserializing %v, expected %v, got %v", c.in, c.want, err)
+		}
+	}
+
+	sizes := []struct {
+		in   uint32
+		want error
+	}{
+		{9, ErrPacketTruncated},
+		{defaultMaxPacketSize + 1, ErrPacketOversized},
+		{0xFFFFFFFF, ErrPacketTruncated},
+	}
+	for _, c := range sizes {
+		serverCon, clientCon := net.Pipe()
+		go func() {
+			_, _ = serverCon.Write(binary.LittleEndian.AppendUint32(nil, c.in))
+		}()
+		_, err := (&client{con: clientCon}).receivePacket()
+		var sizeErr *PacketSizeError
+		if !errors.Is(err, c.want) || !errors.As(err, &sizeErr) {
+			t.Errorf("Receiving packet of size %v, expected %v, got %v", c.in, c.want, err)
+		} else if sizeErr.Size != int(int32(c.in)) {
+			t.Errorf("Receiving packet of size %v, expected size %v in error, got %v", c.in, int32(c.in), sizeErr.Size)
+		}
+		_ = serverCon.Close()
+		_ = clientCon.Close()
+	}
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	// A response with a body one byte longer than the default allows
+	long := strings.Repeat("x", defaultMaxPacketSize-minPacketSize+1)
+	cases := []struct {
+		opts    []Option
+		wantErr bool
+	}{
+		{nil, true},
+		{[]Option{WithMaxPacketSize(1 << 16)}, false},
+		{[]Option{WithMaxPacketSize(1 << 16), WithMaxPacketSize(0)}, true},
+		{[]Option{WithDialect(DialectFactorio)}, false},
+	}
+	for i, c := range cases {
+		serverCon, clientCon := net.Pipe()
+		go serve(serverCon, func(cmd string) string {
+			return long
+		})
+		conn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword, c.opts...)
+		if err != nil {
+			t.Fatalf("Encountered error while connecting: %v", err)
+		}
+		got, err := conn.SendCommand("long")
+		var sizeErr *PacketSizeError
+		if c.wantErr && !errors.As(err, &sizeErr) {
+			t.Errorf("Case %v, expected packet size error, got %v", i, err)
+		}
+		if !c.wantErr && (err != nil || got != long) {
+			t.Errorf("Case %v, expected response of length %v, got length %v and error %v", i, len(long), len(got), err)
+		}
+		conn.Close()
+		_ = serverCon.Close()
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	serverCon, clientCon := net.Pipe()
+	defer serverCon.Close()
+	go func() {
+		server := client{con: serverCon}
+		if _, err := server.receivePacket(); err != nil {
+			return
+		}
+		// A response value with a body, where an empty one is expected ahead of the authentication response
+		_ = server.sendPacket(Packet{0, ServerdataResponseValue, "unexpected"})
+	}()
+	err := authenticate(context.Background(), NewConn(clientCon), testPassword, true)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+	want := ProtocolError{Stage: "auth ping", ExpectedID: 0, ExpectedType: ServerdataResponseValue, ExpectedBody: "",
+		ReceivedID: 0, ReceivedType: ServerdataResponseValue, ReceivedBody: "unexpected"}
+	if *protocolErr != want {
+		t.Errorf("Expected protocol error %+v, got %+v", want, *protocolErr)
+	}
+}
+
+func TestFailureUnwrap(t *testing.T) {
+	// Nothing listens on the port of a listener which has been closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Encountered error while listening: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	_, err = NewRCONConnection("127.0.0.1", port, testPassword)
+	var connFailure ConnectionFailure
+	var opErr *net.OpError
+	if !errors.As(err, &connFailure) || !errors.As(err, &opErr) {
+		t.Errorf("Expected connection failure wrapping a *net.OpError, got %v", err)
+	}
+
+	cause := errors.New("Bad rcon_password.")
+	err = &AuthenticationFailure{cause}
+	var authFailure *AuthenticationFailure
+	if !errors.As(err, &authFailure) || !errors.Is(err, cause) {
+		t.Errorf("Expected authentication failure wrapping %v, got %v", cause, err)
+	}
+}
@@ -1,9 +1,10 @@
 module github.com/vibeisveryo/rcon
 
-go 1.19
+go 1.21
 
 require (
 	github.com/BurntSushi/toml v1.2.1
 	github.com/cheynewallace/tabby v1.1.1
+	github.com/gorilla/websocket v1.5.0
 	github.com/spf13/pflag v1.0.5
 )
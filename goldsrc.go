@@ -0,0 +1,320 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon/internal/wire"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of connectionless GoldSrc packets; a split packet is one part of a larger connectionless packet.
+var (
+	goldSrcHeader      = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+	goldSrcSplitHeader = []byte{0xFE, 0xFF, 0xFF, 0xFF}
+)
+
+const (
+	// goldSrcResponseWait is how long to wait for the first datagram of a response; servers send nothing at all for
+	// commands without output, so silence for this long is taken as an empty response
+	goldSrcResponseWait = 2 * time.Second
+	// goldSrcResponseGap is how long to wait for further datagrams once a response has started arriving; there is no
+	// end marker, so the response is taken as complete once the server falls silent for this long
+	goldSrcResponseGap = 250 * time.Millisecond
+)
+
+// A GoldSrcConnection connects to a remote server using the connectionless "challenge rcon" protocol of GoldSrc
+// (Half-Life 1) and Quake-engine servers, documented in the following link. The protocol runs over UDP and is unrelated
+// to Source RCON, but commands are sent in the same way as with an RCONConnection.
+// https://developer.valvesoftware.com/wiki/Server_queries#Goldsource_RCON
+//
+// A GoldSrcConnection should be created with NewGoldSrcConnection or NewGoldSrcConnectionContext, and is safe for
+// concurrent use by multiple goroutines. Responses carry nothing to tell which command they answer, so commands are
+// sent one at a time.
+type GoldSrcConnection struct {
+	con      net.Conn
+	opts     options
+	password secret
+
+	// responseWait and responseGap are goldSrcResponseWait and goldSrcResponseGap, other than in tests
+	responseWait time.Duration
+	responseGap  time.Duration
+
+	// mu serialises commands, and guards the fields below
+	mu        sync.Mutex
+	challenge string
+	// stale is set when a command is abandoned, since the rest of its response may still arrive
+	stale  bool
+	closed bool
+}
+
+// NewGoldSrcConnection requests an rcon challenge from the GoldSrc or Quake-engine server at the given host and port.
+// The protocol has no login step, so a wrong password only shows when a command is sent. The password cannot hold
+// quotes or newlines.
+func NewGoldSrcConnection(host string, port int, password string, opts ...Option) (*GoldSrcConnection, error) {
+	return NewGoldSrcConnectionContext(context.Background(), host, port, password, opts...)
+}
+
+// NewGoldSrcConnectionContext is like NewGoldSrcConnection, but abandons requesting the challenge once ctx is done, in
+// which case it returns ctx.Err().
+func NewGoldSrcConnectionContext(ctx context.Context, host string, port int, password string,
+	opts ...Option) (*GoldSrcConnection, error) {
+	if strings.ContainsAny(password, "\"\n") {
+		return nil, errors.New("cannot have quotes or newlines in password")
+	}
+	o, err := serverOptions(host, port, opts)
+	if err != nil {
+		return nil, err
+	}
+	con, err := o.dialServer(ctx, "udp", host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &GoldSrcConnection{
+		con:          con,
+		opts:         o,
+		password:     secret(password),
+		responseWait: goldSrcResponseWait,
+		responseGap:  goldSrcResponseGap,
+	}
+	challengeCtx, cancel := o.commandContext(ctx)
+	defer cancel()
+	conn.challenge, err = conn.getChallenge(challengeCtx)
+	if err != nil {
+		_ = con.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// SendCommand takes a command string, sends it to the server, and returns the output as a string. It returns an
+// AuthenticationFailure if the server rejects the password, or rejects a new challenge as well as the old one, and a
+// non-nil error on send or read failure.
+func (conn *GoldSrcConnection) SendCommand(cmd string) (string, error) {
+	return conn.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext is like SendCommand, but gives up waiting on the server once ctx is done and returns ctx.Err().
+// The connection remains usable; whatever remains of the response is discarded before the next command.
+func (conn *GoldSrcConnection) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	return conn.opts.auditCommand(ctx, cmd, conn.sendCommand)
+}
+
+// sendCommand implements SendCommandContext.
+func (conn *GoldSrcConnection) sendCommand(ctx context.Context, cmd string) (string, error) {
+	ctx, cancel := conn.opts.commandContext(ctx)
+	defer cancel()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closed {
+		return "", errClosed
+	}
+	if conn.stale {
+		conn.discard()
+		conn.stale = false
+	}
+
+	// A server which has restarted, or which has been sent a challenge by someone else with the same address, rejects
+	// the old challenge; in that case a new one is requested and the command sent once more. A server which rejects the
+	// new challenge too will not take commands from this client at all
+	for attempt := 0; ; attempt++ {
+		if conn.challenge == "" {
+			challenge, err := conn.getChallenge(ctx)
+			if err != nil {
+				return "", err
+			}
+			conn.challenge = challenge
+		}
+		err := conn.send(ctx, fmt.Sprintf("rcon %s \"%s\" %s", conn.challenge, string(conn.password), cmd))
+		if err != nil {
+			return "", err
+		}
+		response, err := conn.receiveResponse(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				conn.stale = true
+			}
+			return "", err
+		}
+		switch {
+		case strings.HasPrefix(response, "Bad challenge") && attempt == 0:
+			conn.challenge = ""
+		case strings.HasPrefix(response, "Bad challenge"):
+			conn.challenge = ""
+			return "", &AuthenticationFailure{errors.New(strings.TrimSpace(response))}
+		case strings.HasPrefix(response, "Bad rcon_password"):
+			return "", &AuthenticationFailure{errors.New(strings.TrimSpace(response))}
+		default:
+			return response, nil
+		}
+	}
+}
+
+// Close closes the connection. Commands in flight on other goroutines return with an error.
+func (conn *GoldSrcConnection) Close() {
+	// The socket is closed before taking the lock, so as to unblock a command waiting on the server
+	_ = conn.con.Close()
+	conn.mu.Lock()
+	conn.closed = true
+	conn.mu.Unlock()
+}
+
+// getChallenge asks the server for the challenge number to be sent with each command. Datagrams other than the
+// server's answer are ignored.
+func (conn *GoldSrcConnection) getChallenge(ctx context.Context) (string, error) {
+	err := conn.send(ctx, "challenge rcon\n")
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 65536)
+	for {
+		var n int
+		err := wire.Interruptible(ctx, conn.con.SetReadDeadline, func() (err error) {
+			n, err = conn.con.Read(buf)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		conn.opts.logger.Debug("receive GoldSrc packet", "size", n, "body", string(buf[:n]))
+		if !bytes.HasPrefix(buf[:n], goldSrcHeader) {
+			continue
+		}
+		// The answer reads "challenge rcon <number>"
+		fields := strings.Fields(strings.TrimRight(string(buf[len(goldSrcHeader):n]), "\x00"))
+		if len(fields) == 3 && fields[0] == "challenge" && fields[1] == "rcon" {
+			return fields[2], nil
+		}
+	}
+}
+
+// send writes a connectionless packet with the given contents to the server.
+func (conn *GoldSrcConnection) send(ctx context.Context, contents string) error {
+	data := append(append([]byte(nil), goldSrcHeader...), contents...)
+	// Commands carry the password, quoted, after the challenge
+	body := strings.Replace(contents, "\""+string(conn.password)+"\"", redacted, 1)
+	conn.opts.logger.Debug("send GoldSrc packet", "size", len(data), "body", body)
+	defer clear(data)
+	return wire.Interruptible(ctx, conn.con.SetWriteDeadline, func() error {
+		_, err := conn.con.Write(data)
+		return err
+	})
+}
+
+// receiveResponse reads the datagrams of a command response until the server falls silent, and returns the text they
+// carry. Parts of split packets are put back in order; otherwise, datagrams are taken in the order they arrive.
+func (conn *GoldSrcConnection) receiveResponse(ctx context.Context) (string, error) {
+	var response strings.Builder
+	splits := make(map[uint32][][]byte)
+	buf := make([]byte, 65536)
+	wait := conn.responseWait
+	for {
+		var n int
+		readCtx, cancel := context.WithTimeout(ctx, wait)
+		err := wire.Interruptible(readCtx, conn.con.SetReadDeadline, func() (err error) {
+			n, err = conn.con.Read(buf)
+			return err
+		})
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				return response.String(), nil
+			}
+			return "", err
+		}
+		wait = conn.responseGap
+		conn.opts.logger.Debug("receive GoldSrc packet", "size", n, "body", string(buf[:n]))
+		data := buf[:n]
+		if bytes.HasPrefix(data, goldSrcSplitHeader) {
+			data = reassembleGoldSrc(splits, data)
+		}
+		if bytes.HasPrefix(data, goldSrcHeader) {
+			response.WriteString(goldSrcText(data[len(goldSrcHeader):]))
+		}
+	}
+}
+
+// discard reads and drops whatever arrives from the server until it falls silent.
+func (conn *GoldSrcConnection) discard() {
+	buf := make([]byte, 65536)
+	_ = conn.con.SetReadDeadline(time.Now().Add(conn.responseGap))
+	defer func() {
+		_ = conn.con.SetReadDeadline(time.Time{})
+	}()
+	for {
+		if _, err := conn.con.Read(buf); err != nil {
+			return
+		}
+		_ = conn.con.SetReadDeadline(time.Now().Add(conn.responseGap))
+	}
+}
+
+// reassembleGoldSrc records a part of a split packet in splits, which is keyed by packet id. Once every part of a
+// packet has arrived, it returns the whole packet; otherwise it returns nil.
+//
+// A part has the split header, a 4-byte packet id, then a byte holding the index of the part in its upper 4 bits and
+// the number of parts in its lower 4 bits.
+func reassembleGoldSrc(splits map[uint32][][]byte, data []byte) []byte {
+	r := wire.NewReader(data)
+	r.Take(4)
+	id, b := r.Uint32(), r.Uint8()
+	if r.Err() != nil {
+		return nil
+	}
+	index, total := int(b>>4), int(b&0x0F)
+	if total == 0 || index >= total {
+		return nil
+	}
+	parts := splits[id]
+	if parts == nil {
+		parts = make([][]byte, total)
+		splits[id] = parts
+	}
+	if index >= len(parts) || parts[index] != nil {
+		return nil
+	}
+	parts[index] = append([]byte(nil), r.Remaining()...)
+	for _, part := range parts {
+		if part == nil {
+			return nil
+		}
+	}
+	delete(splits, id)
+	return bytes.Join(parts, nil)
+}
+
+// goldSrcText returns the text carried by a print packet, without its header. GoldSrc servers mark print packets with
+// an 'l', QuakeWorld servers with an 'n', and Quake II servers with the word "print" on a line of its own.
+func goldSrcText(data []byte) string {
+	text := string(data)
+	switch {
+	case strings.HasPrefix(text, "print\n"):
+		text = text[len("print\n"):]
+	case strings.HasPrefix(text, "l"), strings.HasPrefix(text, "n"):
+		text = text[1:]
+	default:
+		return ""
+	}
+	return strings.TrimRight(text, "\x00")
+}
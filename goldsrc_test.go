@@ -0,0 +1,155 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveGoldSrc answers GoldSrc clients on pc until it is closed. The given number of challenges it hands out first are
+// rejected, as they are by a server which has restarted. It answers the command "lines" with three print packets,
+// "split" with a packet split in three out-of-order parts, sends nothing at all for "silent", and echoes any other
+// command.
+func serveGoldSrc(pc net.PacketConn, rejected int) {
+	buf := make([]byte, 65536)
+	challenges := 0
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply := func(data string) {
+			_, _ = pc.WriteTo([]byte(data), addr)
+		}
+		printText := func(text string) {
+			reply("\xFF\xFF\xFF\xFFl" + text + "\x00")
+		}
+		request := strings.TrimPrefix(string(buf[:n]), "\xFF\xFF\xFF\xFF")
+		if request == "challenge rcon\n" {
+			challenges++
+			reply(fmt.Sprintf("\xFF\xFF\xFF\xFFchallenge rcon %d\n\x00", challenges))
+			continue
+		}
+		var challenge, password, cmd string
+		_, _ = fmt.Sscanf(request, "rcon %s %q", &challenge, &password)
+		cmd = request[len(fmt.Sprintf("rcon %s %q ", challenge, password)):]
+		switch {
+		case challenge != fmt.Sprint(challenges) || challenges <= rejected:
+			printText("Bad challenge.\n")
+		case password != testPassword:
+			printText("Bad rcon_password.\n")
+		case cmd == "lines":
+			printText("foo\n")
+			printText("bar\n")
+			printText("baz\n")
+		case cmd == "split":
+			packet := "\xFF\xFF\xFF\xFFlfoobarbaz\x00"
+			part := func(index int, data string) string {
+				return "\xFE\xFF\xFF\xFF\x07\x00\x00\x00" + string(rune(index<<4|3)) + data
+			}
+			reply(part(2, packet[8:]))
+			reply(part(0, packet[:4]))
+			reply(part(1, packet[4:8]))
+		case cmd == "silent":
+		default:
+			printText(cmd)
+		}
+	}
+}
+
+// newGoldSrcConnection starts serveGoldSrc on a loopback port, rejecting the given number of challenges, and connects
+// to it with the given password.
+func newGoldSrcConnection(t *testing.T, password string, rejected int) *GoldSrcConnection {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Encountered error while listening: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = pc.Close()
+	})
+	go serveGoldSrc(pc, rejected)
+	conn, err := NewGoldSrcConnection("127.0.0.1", pc.LocalAddr().(*net.UDPAddr).Port, password,
+		WithCommandTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	conn.responseWait = 100 * time.Millisecond
+	conn.responseGap = 20 * time.Millisecond
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestGoldSrcConnection(t *testing.T) {
+	conn := newGoldSrcConnection(t, testPassword, 1)
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"status", "status"},
+		{"lines", "foo\nbar\nbaz\n"},
+		{"split", "foobarbaz"},
+		{"silent", ""},
+	}
+	for _, c := range cases {
+		got, err := conn.SendCommand(c.in)
+		if err != nil {
+			t.Errorf("Encountered error while sending command %q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Command %q, expected response %q, got %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestGoldSrcWrongPassword(t *testing.T) {
+	conn := newGoldSrcConnection(t, "wrong", 1)
+	_, err := conn.SendCommand("status")
+	if _, ok := err.(*AuthenticationFailure); !ok {
+		t.Errorf("Expected authentication failure, got %v", err)
+	}
+}
+
+func TestGoldSrcBadChallenge(t *testing.T) {
+	// The command is sent once more with a new challenge, but not again if that is rejected too
+	conn := newGoldSrcConnection(t, testPassword, 1<<30)
+	got, err := conn.SendCommand("status")
+	if _, ok := err.(*AuthenticationFailure); !ok {
+		t.Errorf("Expected authentication failure, got %q, %v", got, err)
+	}
+}
+
+func TestGoldSrcText(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"lhello\n\x00", "hello\n"},
+		{"nhello\n", "hello\n"},
+		{"print\nhello\n", "hello\n"},
+		{"challenge rcon 1\n", ""},
+	}
+	for _, c := range cases {
+		got := goldSrcText([]byte(c.in))
+		if got != c.want {
+			t.Errorf("Packet %q, expected text %q, got %q", c.in, c.want, got)
+		}
+	}
+}
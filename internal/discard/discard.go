@@ -0,0 +1,44 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+// Package discard provides the logger used throughout the module when none is configured.
+package discard
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger drops every record without formatting it.
+var Logger = slog.New(handler{})
+
+type handler struct{}
+
+func (handler) Enabled(context.Context, slog.Level) bool {
+	return false
+}
+
+func (handler) Handle(context.Context, slog.Record) error {
+	return nil
+}
+
+func (h handler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h handler) WithGroup(string) slog.Handler {
+	return h
+}
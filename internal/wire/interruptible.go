@@ -0,0 +1,64 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package wire
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// Interruptible runs f, which performs blocking operations on a connection, with setDeadline tracking the deadline and
+// cancellation of ctx: the deadline of ctx is set before f runs, and once ctx is done a deadline in the past is set,
+// unblocking any pending operation. The deadline is cleared when f returns. If ctx is done by then, ctx.Err() is
+// returned in place of the error of f; a deadline exceeded on the connection is reported as context.DeadlineExceeded.
+func Interruptible(ctx context.Context, setDeadline func(time.Time) error, f func() error) error {
+	if ctx.Done() == nil {
+		return f()
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		_ = setDeadline(deadline)
+	}
+	// Watch ctx in the background; a deadline in the past unblocks any pending operation immediately
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = setDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	err := f()
+	close(done)
+	<-stopped
+	_ = setDeadline(time.Time{})
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// The connection deadline can fire marginally before ctx notices its own
+	if hasDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
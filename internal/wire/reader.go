@@ -0,0 +1,117 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+// Package wire provides the helpers shared by the packages of the module for reading little-endian packets and for
+// bounding network operations by a context.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrTruncated is the error of a Reader which has been read past the end of its data.
+var ErrTruncated = errors.New("invalid data - too short")
+
+// A Reader reads the little-endian fields of a packet in order. The first read past the end of the data sets the error
+// returned by Err, after which every read returns a zero value, so that a whole packet can be parsed before checking
+// for errors.
+type Reader struct {
+	data []byte
+	err  error
+}
+
+// NewReader returns a Reader reading data from the start.
+func NewReader(data []byte) Reader {
+	return Reader{data: data}
+}
+
+// Err returns the error which stopped the Reader, or nil if every read so far has succeeded.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Remaining returns the data not yet read.
+func (r *Reader) Remaining() []byte {
+	return r.data
+}
+
+// Take returns the next n bytes of data, or nil if there are not that many left.
+func (r *Reader) Take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = ErrTruncated
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *Reader) Uint8() uint8 {
+	b := r.Take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *Reader) Uint16() uint16 {
+	b := r.Take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *Reader) Uint32() uint32 {
+	b := r.Take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *Reader) Uint64() uint64 {
+	b := r.Take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *Reader) Float32() float32 {
+	return math.Float32frombits(r.Uint32())
+}
+
+// String reads a zero-terminated string.
+func (r *Reader) String() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.data, 0)
+	if i < 0 {
+		r.err = errors.New("invalid data - string not zero-terminated")
+		return ""
+	}
+	s := string(r.data[:i])
+	r.data = r.data[i+1:]
+	return s
+}
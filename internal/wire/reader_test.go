@@ -0,0 +1,65 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	data := []byte{1, 2, 0}
+	data = append(data, "three\x00"...)
+	data = binary.LittleEndian.AppendUint32(data, math.Float32bits(4.5))
+	data = binary.LittleEndian.AppendUint64(data, 1<<40)
+	r := NewReader(data)
+	if got := r.Uint8(); got != 1 {
+		t.Errorf("Expected byte 1, got %v", got)
+	}
+	if got := r.Uint16(); got != 2 {
+		t.Errorf("Expected short 2, got %v", got)
+	}
+	if got := r.String(); got != "three" {
+		t.Errorf("Expected string %q, got %q", "three", got)
+	}
+	if got := r.Float32(); got != 4.5 {
+		t.Errorf("Expected float 4.5, got %v", got)
+	}
+	if got := r.Uint64(); got != 1<<40 {
+		t.Errorf("Expected long %v, got %v", uint64(1<<40), got)
+	}
+	if r.Err() != nil {
+		t.Errorf("Encountered error while reading: %v", r.Err())
+	}
+	if got := r.Uint8(); got != 0 || !errors.Is(r.Err(), ErrTruncated) {
+		t.Errorf("Expected error reading past end, got byte %v and error %v", got, r.Err())
+	}
+	// Once stopped, the reader stays stopped
+	if got := r.Take(0); got != nil {
+		t.Errorf("Expected nothing read after error, got %v", got)
+	}
+
+	unterminated := NewReader([]byte("abc"))
+	if got := unterminated.String(); got != "" || unterminated.Err() == nil {
+		t.Errorf("Expected error reading unterminated string, got %q and error %v", got, unterminated.Err())
+	}
+	if got := unterminated.Remaining(); string(got) != "abc" {
+		t.Errorf("Expected %q to remain, got %q", "abc", got)
+	}
+}
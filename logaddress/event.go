@@ -0,0 +1,124 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package logaddress
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// A Player identifies a player in a log line, which names them as "Name<UserID><SteamID><Team>".
+type Player struct {
+	Name string
+	// UserID is the number the server gave the player for this session, as used by commands such as kickid
+	UserID int
+	// SteamID is the player's Steam ID, such as "[U:1:12345]" or "STEAM_1:0:12345", or "BOT" for a bot
+	SteamID string
+	// Team is the player's team, which is empty before they have joined one
+	Team string
+}
+
+// An Event is a log message parsed by Parse; it is one of the types below.
+type Event interface {
+	event()
+}
+
+// Say is a chat message; TeamOnly is true if it was sent with say_team.
+type Say struct {
+	Player   Player
+	TeamOnly bool
+	Text     string
+}
+
+// Kill is a player killing another.
+type Kill struct {
+	Killer Player
+	Victim Player
+	Weapon string
+}
+
+// Connected is a player connecting to the server, from the given address.
+type Connected struct {
+	Player  Player
+	Address string
+}
+
+// Disconnected is a player leaving the server, for the given reason.
+type Disconnected struct {
+	Player Player
+	Reason string
+}
+
+// EnteredGame is a player finishing connecting and spawning into the game.
+type EnteredGame struct {
+	Player Player
+}
+
+// JoinedTeam is a player joining a team.
+type JoinedTeam struct {
+	Player Player
+	Team   string
+}
+
+func (Say) event()          {}
+func (Kill) event()         {}
+func (Connected) event()    {}
+func (Disconnected) event() {}
+func (EnteredGame) event()  {}
+func (JoinedTeam) event()   {}
+
+// playerPattern matches a quoted player, capturing the name, user ID, Steam ID and team. The name is matched lazily,
+// since it may contain anything, including angle brackets and quotes.
+const playerPattern = `"(.*?)<(-?\d+)><([^>]*)><([^>]*)>"`
+
+var (
+	sayPattern          = regexp.MustCompile(`^` + playerPattern + ` (say|say_team) "(.*)"$`)
+	killPattern         = regexp.MustCompile(`^` + playerPattern + ` killed ` + playerPattern + ` with "([^"]*)"`)
+	connectedPattern    = regexp.MustCompile(`^` + playerPattern + ` connected, address "([^"]*)"`)
+	disconnectedPattern = regexp.MustCompile(`^` + playerPattern + ` disconnected \(reason "(.*)"\)`)
+	enteredGamePattern  = regexp.MustCompile(`^` + playerPattern + ` entered the game`)
+	joinedTeamPattern   = regexp.MustCompile(`^` + playerPattern + ` joined team "([^"]*)"`)
+)
+
+// Parse parses a log message, without its timestamp, into one of the Event types. It returns nil for messages of any
+// other kind.
+func Parse(message string) Event {
+	if m := sayPattern.FindStringSubmatch(message); m != nil {
+		return Say{Player: player(m[1:5]), TeamOnly: m[5] == "say_team", Text: m[6]}
+	}
+	if m := killPattern.FindStringSubmatch(message); m != nil {
+		return Kill{Killer: player(m[1:5]), Victim: player(m[5:9]), Weapon: m[9]}
+	}
+	if m := connectedPattern.FindStringSubmatch(message); m != nil {
+		return Connected{Player: player(m[1:5]), Address: m[5]}
+	}
+	if m := disconnectedPattern.FindStringSubmatch(message); m != nil {
+		return Disconnected{Player: player(m[1:5]), Reason: m[5]}
+	}
+	if m := enteredGamePattern.FindStringSubmatch(message); m != nil {
+		return EnteredGame{Player: player(m[1:5])}
+	}
+	if m := joinedTeamPattern.FindStringSubmatch(message); m != nil {
+		return JoinedTeam{Player: player(m[1:5]), Team: m[5]}
+	}
+	return nil
+}
+
+// player builds a Player from the four submatches of playerPattern.
+func player(m []string) Player {
+	userID, _ := strconv.Atoi(m[1])
+	return Player{Name: m[0], UserID: userID, SteamID: m[2], Team: m[3]}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package logaddress
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	alice := Player{Name: "alice", UserID: 2, SteamID: "[U:1:1]", Team: "Red"}
+	bob := Player{Name: "bob <3>", UserID: 3, SteamID: "[U:1:2]", Team: "Blue"}
+	cases := []struct {
+		in   string
+		want Event
+	}{
+		{`"alice<2><[U:1:1]><Red>" say "gg"`, Say{Player: alice, Text: "gg"}},
+		{`"alice<2><[U:1:1]><Red>" say_team "push "now""`, Say{Player: alice, TeamOnly: true, Text: `push "now"`}},
+		{`"alice<2><[U:1:1]><Red>" killed "bob <3><3><[U:1:2]><Blue>" with "scattergun" (attacker_position "1 2 3")`,
+			Kill{Killer: alice, Victim: bob, Weapon: "scattergun"}},
+		{`"alice<2><[U:1:1]><>" connected, address "192.0.2.1:27005"`,
+			Connected{Player: Player{Name: "alice", UserID: 2, SteamID: "[U:1:1]"}, Address: "192.0.2.1:27005"}},
+		{`"bob <3><3><[U:1:2]><Blue>" disconnected (reason "Disconnect by user.")`,
+			Disconnected{Player: bob, Reason: "Disconnect by user."}},
+		{`"alice<2><[U:1:1]><>" entered the game`, EnteredGame{Player: Player{Name: "alice", UserID: 2,
+			SteamID: "[U:1:1]"}}},
+		{`"alice<2><[U:1:1]><Unassigned>" joined team "Red"`, JoinedTeam{Player: Player{Name: "alice", UserID: 2,
+			SteamID: "[U:1:1]", Team: "Unassigned"}, Team: "Red"}},
+		{`World triggered "Round_Start"`, nil},
+	}
+	for _, c := range cases {
+		got := Parse(c.in)
+		if got != c.want {
+			t.Errorf("Message %q, expected event %+v, got %+v", c.in, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+// Package logaddress receives the console log that Source servers stream over UDP to the addresses registered with
+// the logaddress_add command, which is the only way to learn of kills, chat and connections as they happen.
+package logaddress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon"
+	"github.com/vibeisveryo/rcon/internal/discard"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log packet types; a server with sv_logsecret set sends packetSecret followed by the secret instead of packetPlain.
+const (
+	packetPlain  = 'R'
+	packetSecret = 'S'
+)
+
+// lineBuffer is how many lines are held for the reader of Lines before more are dropped.
+const lineBuffer = 256
+
+// timeLayout is the layout of the timestamp at the start of every log line.
+const timeLayout = "01/02/2006 - 15:04:05"
+
+var header = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+// A Line is a single line of a server's log.
+type Line struct {
+	// Source is the address the line was sent from
+	Source net.Addr
+	// Time is when the line was logged, as given by the server, which does not say which time zone it is in; it is
+	// taken to be the local time zone
+	Time time.Time
+	// Message is the line without its timestamp or trailing newline
+	Message string
+	// Event is the parsed message, or nil if the message is not of a kind Parse recognises
+	Event Event
+}
+
+// An Option configures a Listener. Options are passed to Listen, and are applied in order, so a later option overrides
+// an earlier one.
+type Option func(*Listener)
+
+// WithLogger sets the logger the Listener reports to; by default nothing is logged. Every line received is logged at
+// debug level, and packets which are not accepted and lines which are dropped at warning level. A nil logger restores
+// the default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Listener) {
+		l.log = logger
+		if logger == nil {
+			l.log = discard.Logger
+		}
+	}
+}
+
+// WithSecret makes the Listener accept only lines sent with the given sv_logsecret, so that nobody but the server can
+// inject lines. Without it, only lines sent without a secret are accepted.
+func WithSecret(secret string) Option {
+	return func(l *Listener) {
+		l.secret = secret
+	}
+}
+
+// A Listener receives log lines on a UDP port. It should be created with Listen, and its lines read from Lines.
+type Listener struct {
+	pc     net.PacketConn
+	secret string
+	log    *slog.Logger
+	lines  chan Line
+	done   chan struct{}
+}
+
+// Listen starts receiving log lines at the given local address, such as ":27500"; it returns a non-nil error if the
+// address cannot be listened on.
+func Listen(address string, opts ...Option) (*Listener, error) {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		pc:    pc,
+		log:   discard.Logger,
+		lines: make(chan Line, lineBuffer),
+		done:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+// Lines returns a channel delivering the lines received, which is closed once the Listener is closed. Lines are
+// buffered, but dropped if the buffer is full, so the channel should be drained promptly.
+func (l *Listener) Lines() <-chan Line {
+	return l.lines
+}
+
+// Addr returns the local address the Listener receives on.
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// Close stops receiving lines and closes the channel returned by Lines.
+func (l *Listener) Close() error {
+	err := l.pc.Close()
+	<-l.done
+	return err
+}
+
+// Register tells the server on the other end of conn to send its log to the Listener, and returns a function which
+// tells it to stop. The server sends to host, which must be an address of this machine the server can reach, at the
+// port the Listener receives on. If the Listener was given a secret, sv_logsecret is set to it on the server first.
+//
+// Servers only send their log while logging is on, which is done with the "log on" command.
+func (l *Listener) Register(ctx context.Context, conn *rcon.RCONConnection, host string) (func(ctx context.Context) error,
+	error) {
+	udpAddr, ok := l.Addr().(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("listener has no UDP address")
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(udpAddr.Port))
+	if l.secret != "" {
+		_, err := conn.SendCommandContext(ctx, "sv_logsecret "+l.secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err := conn.SendCommandContext(ctx, "logaddress_add "+address)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		_, err := conn.SendCommandContext(ctx, "logaddress_del "+address)
+		return err
+	}, nil
+}
+
+// readLoop receives packets until the Listener is closed, delivering the lines they carry. Packets which are not log
+// lines, or which do not carry the expected secret, are ignored.
+func (l *Listener) readLoop() {
+	defer close(l.done)
+	defer close(l.lines)
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		line, err := parsePacket(buf[:n], l.secret)
+		if err != nil {
+			l.log.Warn("discard log packet", "source", addr, "error", err)
+			continue
+		}
+		line.Source = addr
+		l.log.Debug("receive log line", "source", addr, "message", line.Message)
+		select {
+		case l.lines <- line:
+		default:
+			l.log.Warn("drop log line", "source", addr, "message", line.Message)
+		}
+	}
+}
+
+// parsePacket parses a log packet: the connectionless header, the packet type, the secret if the type calls for one,
+// then the line, which reads "L <timestamp>: <message>" and is terminated by a newline and a zero byte.
+func parsePacket(data []byte, secret string) (Line, error) {
+	if !bytes.HasPrefix(data, header) || len(data) < len(header)+1 {
+		return Line{}, errors.New("not a log packet")
+	}
+	packetType, rest := data[len(header)], string(data[len(header)+1:])
+	switch packetType {
+	case packetPlain:
+		if secret != "" {
+			return Line{}, errors.New("missing secret")
+		}
+	case packetSecret:
+		// The secret runs up to the "L" which starts the line
+		i := strings.Index(rest, "L ")
+		if i < 0 || secret == "" || rest[:i] != secret {
+			return Line{}, errors.New("wrong secret")
+		}
+		rest = rest[i:]
+	default:
+		return Line{}, fmt.Errorf("unknown packet type %q", packetType)
+	}
+	rest = strings.TrimRight(rest, "\x00\n")
+	if !strings.HasPrefix(rest, "L ") || len(rest) < len("L ")+len(timeLayout)+len(": ") {
+		return Line{}, errors.New("not a log line")
+	}
+	timestamp := rest[len("L ") : len("L ")+len(timeLayout)]
+	t, err := time.ParseInLocation(timeLayout, timestamp, time.Local)
+	if err != nil {
+		return Line{}, err
+	}
+	message := strings.TrimPrefix(rest[len("L ")+len(timeLayout):], ": ")
+	return Line{Time: t, Message: message, Event: Parse(message)}, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package logaddress
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParsePacket(t *testing.T) {
+	cases := []struct {
+		in      string
+		secret  string
+		want    string
+		wantErr bool
+	}{
+		{"\xFF\xFF\xFF\xFFRL 10/17/2023 - 21:04:05: Log file started\n\x00", "", "Log file started", false},
+		{"\xFF\xFF\xFF\xFFS1234L 10/17/2023 - 21:04:05: Log file started\n\x00", "1234", "Log file started", false},
+		{"\xFF\xFF\xFF\xFFS4321L 10/17/2023 - 21:04:05: Log file started\n\x00", "1234", "", true},
+		{"\xFF\xFF\xFF\xFFS1234L 10/17/2023 - 21:04:05: Log file started\n\x00", "", "", true},
+		{"\xFF\xFF\xFF\xFFRL 10/17/2023 - 21:04:05: Log file started\n\x00", "1234", "", true},
+		{"\xFF\xFF\xFF\xFFRL garbage\n\x00", "", "", true},
+		{"RL 10/17/2023 - 21:04:05: Log file started\n\x00", "", "", true},
+	}
+	for _, c := range cases {
+		got, err := parsePacket([]byte(c.in), c.secret)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Packet %q with secret %q, expected error %v, got %v", c.in, c.secret, c.wantErr, err)
+			continue
+		}
+		if got.Message != c.want {
+			t.Errorf("Packet %q with secret %q, expected message %q, got %q", c.in, c.secret, c.want, got.Message)
+		}
+	}
+
+	line, _ := parsePacket([]byte("\xFF\xFF\xFF\xFFRL 10/17/2023 - 21:04:05: Log file started\n\x00"), "")
+	want := time.Date(2023, 10, 17, 21, 4, 5, 0, time.Local)
+	if !line.Time.Equal(want) {
+		t.Errorf("Expected time %v, got %v", want, line.Time)
+	}
+}
+
+func TestListener(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", WithSecret("1234"))
+	if err != nil {
+		t.Fatalf("Encountered error while listening: %v", err)
+	}
+	con, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Encountered error while dialing: %v", err)
+	}
+	defer con.Close()
+
+	// The line without the secret is ignored
+	_, _ = con.Write([]byte("\xFF\xFF\xFF\xFFRL 10/17/2023 - 21:04:05: \"alice<2><[U:1:1]><Red>\" say \"forged\"\n\x00"))
+	_, _ = con.Write([]byte("\xFF\xFF\xFF\xFFS1234L 10/17/2023 - 21:04:05: \"alice<2><[U:1:1]><Red>\" say \"gg\"\n\x00"))
+	select {
+	case line := <-l.Lines():
+		want := Say{Player: Player{Name: "alice", UserID: 2, SteamID: "[U:1:1]", Team: "Red"}, Text: "gg"}
+		if line.Event != want {
+			t.Errorf("Expected event %+v, got %+v", want, line.Event)
+		}
+		if line.Source.String() != con.LocalAddr().String() {
+			t.Errorf("Expected source %v, got %v", con.LocalAddr(), line.Source)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for log line")
+	}
+
+	_ = l.Close()
+	if _, ok := <-l.Lines(); ok {
+		t.Errorf("Expected lines channel to be closed")
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"errors"
+	"github.com/vibeisveryo/rcon/internal/discard"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+// defaultDialTimeout is how long dialing may take when no WithDialTimeout option is given.
+const defaultDialTimeout = 4 * time.Second
+
+// An Option configures how an RCONConnection is set up and behaves. Options are passed to NewRCONConnection or
+// NewRCONConnectionContext, and are applied in order, so a later option overrides an earlier one.
+//
+// The constructors of the other connection types take the same options, of which those concerning dialing and timeouts
+// apply to all of them. Every constructor returns an error if the host is empty or the port out of range, and a
+// ConnectionFailure if the server cannot be reached. Since UDP gives no indication that nobody is listening, a deadline
+// on the context or a command timeout is the only way to stop waiting on a server which is down for the protocols
+// carried over it, BattlEye RCon and GoldSrc rcon.
+type Option func(*options)
+
+// options holds the configuration assembled from a list of Option values.
+type options struct {
+	dialTimeout    time.Duration
+	commandTimeout time.Duration
+	dialer         *net.Dialer
+	dialFunc       func(ctx context.Context, network, address string) (net.Conn, error)
+	keepAlive      time.Duration
+	localAddr      net.Addr
+	reconnect      *ReconnectPolicy
+	dialect        Dialect
+	logger         *slog.Logger
+	maxPacketSize  int
+	audit          AuditHook
+}
+
+// newOptions returns the defaults with opts applied on top.
+func newOptions(opts []Option) options {
+	o := options{dialTimeout: defaultDialTimeout, dialect: DialectSRCDS, logger: discard.Logger}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithDialTimeout sets how long establishing the TCP connection may take; the default is 4 seconds. A zero duration
+// removes the limit, leaving only the context passed to NewRCONConnectionContext to bound dialing.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = d
+	}
+}
+
+// WithCommandTimeout sets a deadline for every exchange with the server: each command sent, including the reads and
+// writes of its responses, and the authentication handshake must complete within d. A command which overruns returns
+// context.DeadlineExceeded. By default there is no limit beyond that of any context passed in.
+func WithCommandTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.commandTimeout = d
+	}
+}
+
+// WithDialer dials the server using a copy of d, allowing any of its settings to be tuned. WithKeepAlive and
+// WithLocalAddr take effect on top of it.
+func WithDialer(d *net.Dialer) Option {
+	return func(o *options) {
+		dialer := *d
+		o.dialer = &dialer
+	}
+}
+
+// WithDialFunc dials the server using f instead of a net.Dialer, for example to go through a proxy. The network is
+// "tcp", except for protocols carried over UDP, BattlEye RCon and GoldSrc rcon, for which it is "udp". WithDialer,
+// WithKeepAlive and WithLocalAddr have no effect when a dial function is set.
+func WithDialFunc(f func(ctx context.Context, network, address string) (net.Conn, error)) Option {
+	return func(o *options) {
+		o.dialFunc = f
+	}
+}
+
+// WithKeepAlive sets the interval between TCP keep-alive probes on the connection. A negative duration disables
+// keep-alives; zero uses the operating system default.
+func WithKeepAlive(d time.Duration) Option {
+	return func(o *options) {
+		o.keepAlive = d
+	}
+}
+
+// WithLocalAddr binds the local end of the connection to addr, which should be a *net.TCPAddr, or a *net.UDPAddr for
+// protocols carried over UDP.
+func WithLocalAddr(addr net.Addr) Option {
+	return func(o *options) {
+		o.localAddr = addr
+	}
+}
+
+// WithLogger sets the logger the connection reports to; by default nothing is logged. Every packet sent and received is
+// logged at debug level, with its ID, type, size and body as attributes, but with passwords redacted. Connection
+// failures are logged at warning level. A nil logger restores the default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+		if logger == nil {
+			o.logger = discard.Logger
+		}
+	}
+}
+
+// WithMaxPacketSize sets the largest packet, as given by its size field, accepted from the server; a larger one fails
+// the connection with a *PacketSizeError instead of being read into memory. The default suits the dialect: 4106 bytes,
+// a packet with a 4096-byte body, for servers which split responses over several packets, and 16 MiB for those which
+// send each response as a single packet. A size of zero or less restores the default. Only the Source RCON protocol
+// is affected.
+func WithMaxPacketSize(size int) Option {
+	return func(o *options) {
+		o.maxPacketSize = size
+	}
+}
+
+// serverOptions checks the host and port of a server, and returns the defaults with opts applied on top.
+func serverOptions(host string, port int, opts []Option) (options, error) {
+	if host == "" {
+		return options{}, errors.New("cannot have empty hostname")
+	}
+	if port < 1 || port > 65535 {
+		return options{}, errors.New("cannot have invalid port; must be between 1 and 65535, inclusive")
+	}
+	return newOptions(opts), nil
+}
+
+// dialServer opens a connection to the server at host and port over network as configured, returning dialError on
+// failure.
+func (o options) dialServer(ctx context.Context, network, host string, port int) (net.Conn, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	con, err := o.dial(ctx, network, address)
+	if err != nil {
+		o.logger.Debug("open connection failed", "address", address, "error", err)
+		return nil, dialError(ctx, err)
+	}
+	o.logger.Debug("open connection", "address", address)
+	return con, nil
+}
+
+// dialError returns the error to report for err, which occurred connecting to a server: ctx.Err() if ctx is done, and
+// otherwise a ConnectionFailure if err is a network error.
+func dialError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return ConnectionFailure{netErr}
+	}
+	return err
+}
+
+// dial opens a connection to address over network as configured.
+func (o options) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if o.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.dialTimeout)
+		defer cancel()
+	}
+	if o.dialFunc != nil {
+		return o.dialFunc(ctx, network, address)
+	}
+	var dialer net.Dialer
+	if o.dialer != nil {
+		dialer = *o.dialer
+	}
+	if o.keepAlive != 0 {
+		dialer.KeepAlive = o.keepAlive
+	}
+	if o.localAddr != nil {
+		dialer.LocalAddr = o.localAddr
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// packetSizeLimit returns the largest packet size accepted, as configured.
+func (o options) packetSizeLimit() int {
+	if o.maxPacketSize > 0 {
+		return o.maxPacketSize
+	}
+	if size := o.dialect.MaxPacketSize(); size > 0 {
+		return size
+	}
+	return defaultMaxPacketSize
+}
+
+// commandContext derives the context bounding a single exchange with the server from ctx. Without a command timeout,
+// ctx itself is returned, so that a context which can never be done stays that way and costs nothing to watch.
+func (o options) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.commandTimeout > 0 {
+		return context.WithTimeout(ctx, o.commandTimeout)
+	}
+	return ctx, func() {}
+}
@@ -0,0 +1,369 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Defaults for pool settings not given by a PoolOption.
+const (
+	defaultMaxConnsPerServer   = 4
+	defaultIdleTimeout         = 5 * time.Minute
+	defaultHealthCheckInterval = 30 * time.Second
+)
+
+// errPoolClosed is returned by Get on a pool which has been closed.
+var errPoolClosed = errors.New("pool is closed")
+
+// A Pool hands out authenticated RCONConnections to any number of servers, reusing connections returned to it so that
+// repeated commands to the same server need not each pay for a TCP connect and an authentication round trip.
+// Connections are keyed by host, port and password. Idle connections are health-checked in the background with the
+// same check packet SendCommand uses, and closed once they have gone unused for too long.
+//
+// A Pool is safe for concurrent use by multiple goroutines. It should be created with NewPool.
+type Pool struct {
+	opts poolOptions
+
+	// mu guards the fields below
+	mu      sync.Mutex
+	servers map[poolKey]*poolServer
+	// checkedOut maps each connection currently handed out to the server it belongs to
+	checkedOut map[*RCONConnection]poolKey
+	closed     bool
+
+	// closing is closed by Close to stop the health checker and wake waiting callers; checkerDone is closed once the
+	// health checker has exited
+	closing     chan struct{}
+	checkerDone chan struct{}
+}
+
+// poolKey identifies the server, and credentials, that a pooled connection is authenticated with.
+type poolKey struct {
+	host     string
+	port     int
+	password secret
+}
+
+// poolServer tracks the connections open to a single server.
+type poolServer struct {
+	idle []idleConn
+	// open counts every connection to the server, whether idle, handed out or being dialed
+	open int
+	// changed is closed and replaced whenever a connection becomes idle or a slot frees up
+	changed chan struct{}
+}
+
+// idleConn is a connection waiting in the pool, along with when it was returned.
+type idleConn struct {
+	conn  *RCONConnection
+	since time.Time
+}
+
+// A PoolOption configures a Pool; pass options to NewPool.
+type PoolOption func(*poolOptions)
+
+// poolOptions holds the configuration assembled from a list of PoolOption values.
+type poolOptions struct {
+	maxConnsPerServer   int
+	idleTimeout         time.Duration
+	healthCheckInterval time.Duration
+	connOpts            []Option
+}
+
+// WithMaxConnsPerServer caps the number of connections open at once to any one server, idle or in use; Get blocks
+// while the cap is reached. The default is 4, and zero or less removes the cap.
+func WithMaxConnsPerServer(n int) PoolOption {
+	return func(o *poolOptions) {
+		o.maxConnsPerServer = n
+	}
+}
+
+// WithIdleTimeout sets how long a connection may sit unused in the pool before it is closed; the default is 5
+// minutes. Zero or less keeps idle connections indefinitely.
+func WithIdleTimeout(d time.Duration) PoolOption {
+	return func(o *poolOptions) {
+		o.idleTimeout = d
+	}
+}
+
+// WithHealthCheckInterval sets how often idle connections are checked, and closed if the server does not answer; the
+// default is 30 seconds. Zero or less disables checking.
+func WithHealthCheckInterval(d time.Duration) PoolOption {
+	return func(o *poolOptions) {
+		o.healthCheckInterval = d
+	}
+}
+
+// WithConnectionOptions sets the options with which the pool creates each connection.
+func WithConnectionOptions(opts ...Option) PoolOption {
+	return func(o *poolOptions) {
+		o.connOpts = append([]Option(nil), opts...)
+	}
+}
+
+// NewPool creates an empty Pool configured by opts. Callers should Close the pool once done with it.
+func NewPool(opts ...PoolOption) *Pool {
+	o := poolOptions{
+		maxConnsPerServer:   defaultMaxConnsPerServer,
+		idleTimeout:         defaultIdleTimeout,
+		healthCheckInterval: defaultHealthCheckInterval,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	p := &Pool{
+		opts:        o,
+		servers:     make(map[poolKey]*poolServer),
+		checkedOut:  make(map[*RCONConnection]poolKey),
+		closing:     make(chan struct{}),
+		checkerDone: make(chan struct{}),
+	}
+	go p.checkLoop()
+	return p
+}
+
+// Get returns an authenticated connection to the given server, reusing an idle one if possible and dialing otherwise.
+// If the server already has as many connections as the pool allows, Get waits for one to be returned, or until ctx
+// is done. The connection must be handed back with Put once the caller is finished with it, and not closed directly.
+func (p *Pool) Get(ctx context.Context, host string, port int, password string) (*RCONConnection, error) {
+	key := poolKey{host, port, secret(password)}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errPoolClosed
+		}
+		srv := p.server(key)
+		// Prefer the most recently used idle connection, which is the least likely to have gone stale
+		for len(srv.idle) > 0 {
+			idle := srv.idle[len(srv.idle)-1]
+			srv.idle = srv.idle[:len(srv.idle)-1]
+			if !idle.conn.usable() {
+				srv.open--
+				idle.conn.Close()
+				continue
+			}
+			p.checkedOut[idle.conn] = key
+			p.mu.Unlock()
+			return idle.conn, nil
+		}
+		if p.opts.maxConnsPerServer <= 0 || srv.open < p.opts.maxConnsPerServer {
+			srv.open++
+			p.mu.Unlock()
+			return p.dial(ctx, key)
+		}
+		changed := srv.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-p.closing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Put returns a connection obtained from Get to the pool. Connections which have failed, or which were not obtained
+// from this pool, are closed instead of being kept.
+func (p *Pool) Put(conn *RCONConnection) {
+	p.mu.Lock()
+	key, ok := p.checkedOut[conn]
+	if !ok {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	delete(p.checkedOut, conn)
+	srv := p.server(key)
+	if p.closed || !conn.usable() {
+		srv.open--
+		srv.notify()
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	srv.idle = append(srv.idle, idleConn{conn, time.Now()})
+	srv.notify()
+	p.mu.Unlock()
+}
+
+// SendCommand sends a command to the given server on a pooled connection, as with RCONConnection.SendCommandContext,
+// and returns the connection to the pool afterwards.
+func (p *Pool) SendCommand(ctx context.Context, host string, port int, password string, cmd string) (string, error) {
+	conn, err := p.Get(ctx, host, port, password)
+	if err != nil {
+		return "", err
+	}
+	defer p.Put(conn)
+	return conn.SendCommandContext(ctx, cmd)
+}
+
+// Close closes all idle connections and stops health checking. Connections still handed out are closed as they are
+// returned with Put.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	var idle []idleConn
+	for _, srv := range p.servers {
+		idle = append(idle, srv.idle...)
+		srv.open -= len(srv.idle)
+		srv.idle = nil
+	}
+	close(p.closing)
+	p.mu.Unlock()
+
+	<-p.checkerDone
+	for _, i := range idle {
+		i.conn.Close()
+	}
+}
+
+// dial opens a new connection for key, whose slot has already been counted in open.
+func (p *Pool) dial(ctx context.Context, key poolKey) (*RCONConnection, error) {
+	conn, err := NewRCONConnectionContext(ctx, key.host, key.port, string(key.password), p.opts.connOpts...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	srv := p.server(key)
+	if err != nil {
+		srv.open--
+		srv.notify()
+		return nil, err
+	}
+	if p.closed {
+		srv.open--
+		conn.Close()
+		return nil, errPoolClosed
+	}
+	p.checkedOut[conn] = key
+	return conn, nil
+}
+
+// server returns the bookkeeping for key, creating it if needed. p.mu must be held.
+func (p *Pool) server(key poolKey) *poolServer {
+	srv, ok := p.servers[key]
+	if !ok {
+		srv = &poolServer{changed: make(chan struct{})}
+		p.servers[key] = srv
+	}
+	return srv
+}
+
+// notify wakes any callers of Get waiting on the server. The pool's mutex must be held.
+func (srv *poolServer) notify() {
+	close(srv.changed)
+	srv.changed = make(chan struct{})
+}
+
+// checkLoop periodically evicts and health-checks idle connections until the pool is closed.
+func (p *Pool) checkLoop() {
+	defer close(p.checkerDone)
+	interval := p.opts.healthCheckInterval
+	if interval <= 0 {
+		// Idle connections must still be evicted without health checks
+		interval = p.opts.idleTimeout
+	}
+	if interval <= 0 {
+		<-p.closing
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkIdle()
+		case <-p.closing:
+			return
+		}
+	}
+}
+
+// checkIdle closes idle connections which have exceeded the idle timeout, and pings the rest if health checks are
+// enabled, closing those that fail. Connections are taken out of the pool while being checked.
+func (p *Pool) checkIdle() {
+	now := time.Now()
+	type checked struct {
+		key poolKey
+		idleConn
+	}
+	var toCheck []checked
+	var toClose []*RCONConnection
+
+	p.mu.Lock()
+	for key, srv := range p.servers {
+		kept := srv.idle[:0]
+		evicted := false
+		for _, idle := range srv.idle {
+			switch {
+			case p.opts.idleTimeout > 0 && now.Sub(idle.since) >= p.opts.idleTimeout, !idle.conn.usable():
+				srv.open--
+				evicted = true
+				toClose = append(toClose, idle.conn)
+			case p.opts.healthCheckInterval > 0:
+				toCheck = append(toCheck, checked{key, idle})
+			default:
+				kept = append(kept, idle)
+			}
+		}
+		srv.idle = kept
+		if evicted {
+			srv.notify()
+		}
+		if srv.open == 0 && len(srv.idle) == 0 {
+			delete(p.servers, key)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, conn := range toClose {
+		conn.Close()
+	}
+	for _, c := range toCheck {
+		ctx, cancel := context.WithTimeout(context.Background(), p.opts.healthCheckTimeout())
+		err := c.conn.Ping(ctx)
+		cancel()
+
+		p.mu.Lock()
+		srv := p.server(c.key)
+		if err != nil || p.closed {
+			srv.open--
+			srv.notify()
+			p.mu.Unlock()
+			c.conn.Close()
+			continue
+		}
+		srv.idle = append(srv.idle, c.idleConn)
+		srv.notify()
+		p.mu.Unlock()
+	}
+}
+
+// healthCheckTimeout is how long a health check may wait for the server to answer.
+func (o poolOptions) healthCheckTimeout() time.Duration {
+	if o.healthCheckInterval < 5*time.Second {
+		return o.healthCheckInterval
+	}
+	return 5 * time.Second
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newEchoPool returns a pool whose connections talk to serveEcho over in-memory pipes, along with a count of how many
+// connections it has dialed.
+func newEchoPool(opts ...PoolOption) (*Pool, *int32) {
+	var dials int32
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		serverCon, clientCon := net.Pipe()
+		go serveEcho(serverCon)
+		return clientCon, nil
+	}
+	opts = append([]PoolOption{WithConnectionOptions(WithDialFunc(dial))}, opts...)
+	return NewPool(opts...), &dials
+}
+
+func TestPoolReuse(t *testing.T) {
+	pool, dials := newEchoPool()
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		got, err := pool.SendCommand(context.Background(), "example.com", 27015, testPassword, "echo pooled")
+		if err != nil {
+			t.Errorf("Encountered error while sending command through pool: %v", err)
+		}
+		if got != "pooled" {
+			t.Errorf("Expected response %q, got %q", "pooled", got)
+		}
+	}
+	if atomic.LoadInt32(dials) != 1 {
+		t.Errorf("Expected pool to dial once, dialed %v times", atomic.LoadInt32(dials))
+	}
+
+	// A different password is a different pool entry
+	conn, err := pool.Get(context.Background(), "example.com", 27015, "wrong")
+	if err == nil {
+		pool.Put(conn)
+		t.Errorf("Expected authentication failure for wrong password")
+	}
+}
+
+func TestPoolCap(t *testing.T) {
+	pool, _ := newEchoPool(WithMaxConnsPerServer(1))
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background(), "example.com", 27015, testPassword)
+	if err != nil {
+		t.Fatalf("Encountered error while getting connection: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx, "example.com", 27015, testPassword); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v while pool is at capacity, got %v", context.DeadlineExceeded, err)
+	}
+
+	// Returning the connection unblocks a waiting caller, who gets the same connection
+	time.AfterFunc(20*time.Millisecond, func() {
+		pool.Put(conn)
+	})
+	again, err := pool.Get(context.Background(), "example.com", 27015, testPassword)
+	if err != nil {
+		t.Fatalf("Encountered error while getting returned connection: %v", err)
+	}
+	if again != conn {
+		t.Errorf("Expected returned connection to be reused")
+	}
+	pool.Put(again)
+}
+
+func TestPoolEvictsBroken(t *testing.T) {
+	pool, dials := newEchoPool()
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background(), "example.com", 27015, testPassword)
+	if err != nil {
+		t.Fatalf("Encountered error while getting connection: %v", err)
+	}
+	_, _ = conn.SendCommand("quit")
+	pool.Put(conn)
+
+	if _, err := pool.SendCommand(context.Background(), "example.com", 27015, testPassword, "echo x"); err != nil {
+		t.Errorf("Encountered error while sending command after broken connection: %v", err)
+	}
+	if atomic.LoadInt32(dials) != 2 {
+		t.Errorf("Expected broken connection to be replaced, dialed %v times", atomic.LoadInt32(dials))
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	pool, dials := newEchoPool(WithIdleTimeout(20*time.Millisecond), WithHealthCheckInterval(10*time.Millisecond))
+	defer pool.Close()
+
+	if _, err := pool.SendCommand(context.Background(), "example.com", 27015, testPassword, "echo x"); err != nil {
+		t.Fatalf("Encountered error while sending command: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := pool.SendCommand(context.Background(), "example.com", 27015, testPassword, "echo x"); err != nil {
+		t.Fatalf("Encountered error while sending command: %v", err)
+	}
+	if atomic.LoadInt32(dials) != 2 {
+		t.Errorf("Expected idle connection to be evicted, dialed %v times", atomic.LoadInt32(dials))
+	}
+}
@@ -17,8 +17,11 @@ https://www.gnu.org/licenses.
 package rcon
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"io"
+	"strings"
+	"sync"
 )
 
 /**
@@ -28,125 +31,246 @@ import (
 // An RCONConnection provides an interface with which you can connect to a remote server using the Source RCON protocol
 // documented in the following link. It should not be initialized directly; behaviour is undefined if it is. Its
 // methods use the protocol to authenticate and connect with servers.
+//
+// An RCONConnection is safe for concurrent use by multiple goroutines. Commands sent concurrently are pipelined over
+// the same connection, and a background reader routes each response packet to the command it belongs to by its ID.
 type RCONConnection struct {
-	idCounter int
-	client    *client
+	opts options
+	// redial opens and authenticates a fresh client to the same server, or is nil if that is not possible
+	redial func(ctx context.Context) (*client, error)
+
+	// reconnectMu is held while replacing a broken session, so that only one goroutine redials at a time
+	reconnectMu sync.Mutex
+
+	// mu guards the fields below
+	mu     sync.Mutex
+	sess   *session
+	closed bool
 }
 
+// errClosed is returned by operations on a connection which has been closed with Close.
+var errClosed = errors.New("connection is closed")
+
 // NewRCONConnection authenticates with the provided server given details, and returns a pointer to an RCONConnection
 // for successful connection. It returns a non-nil error on illegal argument or on failure to communicate with the
-// server.
-func NewRCONConnection(host string, port int, password string) (*RCONConnection, error) {
-	// Checks for argument legality
-	if host == "" {
-		return nil, errors.New("cannot have empty hostname")
+// server. Options may be given to tune how the connection is made; see Option.
+func NewRCONConnection(host string, port int, password string, opts ...Option) (*RCONConnection, error) {
+	return NewRCONConnectionContext(context.Background(), host, port, password, opts...)
+}
+
+// NewRCONConnectionContext is like NewRCONConnection, but abandons dialing and authentication once ctx is done, in
+// which case it returns ctx.Err(). No connection is left open on failure.
+func NewRCONConnectionContext(ctx context.Context, host string, port int, password string,
+	opts ...Option) (*RCONConnection, error) {
+	o, err := serverOptions(host, port, opts)
+	if err != nil {
+		return nil, err
 	}
-	if port < 1 || port > 65535 {
-		return nil, errors.New("cannot have invalid port; must be between 1 and 65535, inclusive")
+
+	redial := func(ctx context.Context) (*client, error) {
+		client, err := newClient(ctx, host, port, o)
+		if err != nil {
+			return nil, err
+		}
+		err = authenticateClient(ctx, client, password, o)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	client, err := redial(ctx)
+	if err != nil {
+		return nil, err
 	}
+	conn := newRCONConnection(client, o)
+	conn.redial = redial
+	return conn, nil
+}
 
-	client, err := newClient(host, port)
+// NewRCONConnectionFromConn authenticates over a connection the caller has already established, such as an SSH
+// port-forward or an in-process pipe, and returns an RCONConnection using it. Authentication is abandoned once ctx is
+// done. On success the RCONConnection takes ownership of con and closes it when closed itself; on failure con is
+// closed before returning. Options concerning dialing have no effect, and since there is no way to re-establish con,
+// neither does WithReconnect.
+//
+// If con implements SetDeadline and SetWriteDeadline, as a net.Conn does, these are used to honour cancellation and
+// timeouts. Otherwise blocked reads and writes can only be interrupted by closing con, so a cancelled handshake or a
+// write cancelled while blocked fails the connection.
+func NewRCONConnectionFromConn(ctx context.Context, con io.ReadWriteCloser, password string,
+	opts ...Option) (*RCONConnection, error) {
+	if con == nil {
+		return nil, errors.New("cannot have nil connection")
+	}
+	o := newOptions(opts)
+	client := &client{con: con, log: o.logger, maxSize: o.packetSizeLimit()}
+	err := authenticateClient(ctx, client, password, o)
 	if err != nil {
 		return nil, err
 	}
+	return newRCONConnection(client, o), nil
+}
+
+// newRCONConnection wraps an authenticated client in an RCONConnection.
+func newRCONConnection(client *client, o options) *RCONConnection {
+	return &RCONConnection{
+		opts: o,
+		sess: newSession(client),
+	}
+}
+
+// authenticateClient authenticates over client within the command timeout, closing it on failure.
+func authenticateClient(ctx context.Context, client *client, password string, o options) error {
+	authCtx, cancel := o.commandContext(ctx)
+	defer cancel()
+	err := o.dialect.Authenticate(authCtx, &Conn{client: client, opts: o}, password)
+	if err != nil {
+		client.close()
+		return err
+	}
+	return nil
+}
 
+// authenticate performs the SERVERDATA_AUTH handshake on a freshly opened conn. Source servers send an empty
+// SERVERDATA_RESPONSE_VALUE packet ahead of the authentication response, which is required if expectPreamble is set;
+// other servers may or may not, so otherwise any such packets are skipped.
+func authenticate(ctx context.Context, conn *Conn, password string, expectPreamble bool) error {
 	// Authenticate RCON connection
-	err = client.sendPacket(packet{packetId: 0, packetType: serverdataAuth, packetBody: password})
+	err := conn.WritePacket(ctx, Packet{ID: 0, Type: ServerdataAuth, Body: password})
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// Receive empty SERVERDATA_RESPONSE_VALUE
-	{
-		response, err := client.receivePacket()
+	if expectPreamble {
+		response, err := conn.ReadPacket(ctx)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if (response != packet{0, 0, ""}) {
-			msg := fmt.Sprintf("received unexpected packet (auth ping); expected %v %v %v, got %v %v %v",
-				0, serverdataResponseValue, "", response.packetId, response.packetType, response.packetBody)
-			return nil, errors.New(msg)
+		err = expectPacket("auth ping", Packet{0, ServerdataResponseValue, ""}, response)
+		if err != nil {
+			return err
 		}
 	}
 	// Receive authentication response SERVERDATA_AUTH_RESPONSE
 	{
-		response, err := client.receivePacket()
+		response, err := conn.ReadPacket(ctx)
+		for err == nil && !expectPreamble && response.Type == ServerdataResponseValue {
+			response, err = conn.ReadPacket(ctx)
+		}
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if response.packetType != serverdataAuthResponse {
-			msg := fmt.Sprintf("received unexpected packet (auth response); expected type %v, received %v",
-				serverdataAuthResponse, response.packetType)
-			return nil, errors.New(msg)
+		err = expectType("auth response", ServerdataAuthResponse, response)
+		if err != nil {
+			return err
 		}
-		if response.packetId != 0 {
-			return nil, new(AuthenticationFailure)
+		if response.ID != 0 {
+			return new(AuthenticationFailure)
 		}
 	}
 
-	return &RCONConnection{client: client}, nil
+	return nil
 }
 
 // SendCommand takes a command string, sends it to the server, and returns the output as a string. It returns a non-nil
 // error on send or read failure.
 func (conn *RCONConnection) SendCommand(cmd string) (string, error) {
-	// This method implements the trick, discovered by Koraktor and documented in the following link, to guarantee that
-	// all meaningful responses have been received:
-	// https://developer.valvesoftware.com/wiki/Source_RCON_Protocol#Multiple-packet_Responses
+	return conn.SendCommandContext(context.Background(), cmd)
+}
 
-	// var eofErr error = nil // If EOF is hit while reading this will be set to io.EOF. Any other error
-	// or an error hit while sending should directly return.
+// SendCommandContext is like SendCommand, but gives up waiting on the server once ctx is done and returns ctx.Err()
+// along with whatever output had been received. Any response arriving afterwards is discarded, so the connection
+// remains usable; the exception is cancellation while a packet is partway onto the wire, which leaves the stream
+// unrecoverable and so fails the connection.
+//
+// If the connection was created with WithReconnect and has dropped, it is re-established before the command is sent.
+// Should it drop while the command is in flight, the command is retried once on a new connection if the
+// ReconnectPolicy deems it idempotent.
+func (conn *RCONConnection) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	return conn.opts.auditCommand(ctx, cmd, conn.sendCommandRetrying)
+}
 
-	// Send request packet
-	var requestId = conn.counter()
-	{
-		requestPacket := packet{
-			packetId:   requestId,
-			packetType: serverdataExeccommand,
-			packetBody: cmd,
-		}
-		err := conn.client.sendPacket(requestPacket)
-		if err != nil {
-			return "", err
+// sendCommandRetrying implements SendCommandContext, reconnecting and retrying as the connection allows.
+func (conn *RCONConnection) sendCommandRetrying(ctx context.Context, cmd string) (string, error) {
+	sess, err := conn.session(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := conn.sendCommand(ctx, sess, cmd)
+	if err != nil && sess.broken() && conn.canReconnect() && conn.opts.reconnect.idempotent(cmd) {
+		if sess, reconnectErr := conn.session(ctx); reconnectErr == nil {
+			return conn.sendCommand(ctx, sess, cmd)
 		}
 	}
+	return resp, err
+}
+
+// sendCommand implements SendCommandContext on a particular session, in the connection's dialect.
+func (conn *RCONConnection) sendCommand(ctx context.Context, sess *session, cmd string) (string, error) {
+	ctx, cancel := conn.opts.commandContext(ctx)
+	defer cancel()
+	return conn.opts.dialect.SendCommand(ctx, &Session{sess}, cmd)
+}
 
-	// Send ping packet
-	// This packet will receive TWO responses: one identical (empty body), one more RESPONSE_VALUE with body 0x01 00
-	var pingId = conn.counter()
+// sendCommandSRCDS implements SendCommand for Source engine servers.
+func sendCommandSRCDS(ctx context.Context, sess *Session, cmd string) (string, error) {
+	// This function implements the trick, discovered by Koraktor and documented in the following link, to guarantee
+	// that all meaningful responses have been received:
+	// https://developer.valvesoftware.com/wiki/Source_RCON_Protocol#Multiple-packet_Responses
+
+	// IDs for the request, ping and check packets, in that order
+	c, err := sess.StartCall(3)
+	if err != nil {
+		return "", err
+	}
+	defer c.End()
+	ids := c.IDs()
+	requestId, pingId, checkId := ids[0], ids[1], ids[2]
+
+	// Send request and ping packets, together
+	// The ping packet will receive TWO responses: one identical (empty body), one more RESPONSE_VALUE with body 0x01 00
 	{
-		pingPacket := packet{
-			packetId:   pingId,
-			packetType: serverdataResponseValue,
-			packetBody: "",
+		requestPacket := Packet{
+			ID:   requestId,
+			Type: ServerdataExeccommand,
+			Body: cmd,
+		}
+		pingPacket := Packet{
+			ID:   pingId,
+			Type: ServerdataResponseValue,
+			Body: "",
 		}
-		err := conn.client.sendPacket(pingPacket)
+		err := c.Send(ctx, requestPacket, pingPacket)
 		if err != nil {
 			return "", err
 		}
 	}
 
 	// Receive packet
-	var resp packet
-	var respBody = ""
+	var resp Packet
+	var respBody strings.Builder
 	{
 		{
 			var err error
-			resp, err = conn.client.receivePacket()
+			resp, err = c.Receive(ctx)
 			if err != nil {
-				return respBody, err
+				return "", err
 			}
 		}
 		// Do this while the packet received has ID requestId
 		{
 			var err error
-			for ; resp.packetId == requestId; resp, err = conn.client.receivePacket() {
+			for ; resp.ID == requestId; resp, err = c.Receive(ctx) {
 				if err != nil {
-					return respBody, err
+					return respBody.String(), err
 				}
-				if resp.packetType != serverdataResponseValue {
-					return respBody, errors.New("unexpected response type")
+				err = expectType("response", ServerdataResponseValue, resp)
+				if err != nil {
+					return respBody.String(), err
 				}
-				respBody = respBody + resp.packetBody
+				respBody.WriteString(resp.Body)
+			}
+			if err != nil {
+				return respBody.String(), err
 			}
 		}
 	}
@@ -155,78 +279,123 @@ func (conn *RCONConnection) SendCommand(cmd string) (string, error) {
 		var err error
 		// Receive empty ping packet and check for expectation
 		// Packet has already been received by the last loop! Omit receive here
+		err = expectPacket("ping", Packet{pingId, ServerdataResponseValue, ""}, resp)
 		if err != nil {
-			return respBody, err
-		}
-		if (resp != packet{pingId, serverdataResponseValue, ""}) {
-			msg := fmt.Sprintf("received unexpected response (ping); expected %v %v %v, got %v %v %v",
-				pingId, serverdataResponseValue, "", resp.packetId, resp.packetType, resp.packetBody)
-			return respBody, errors.New(msg)
+			return respBody.String(), err
 		}
 		// Receive ping packet with body 0x00010000 and check for expectation
-		resp, err = conn.client.receivePacket()
+		resp, err = c.Receive(ctx)
 		if err != nil {
-			return respBody, err
+			return respBody.String(), err
 		}
-		if (resp != packet{pingId, serverdataResponseValue, "\x00\x01\x00\x00"}) {
-			msg := fmt.Sprintf("received unexpected response (ping); expected %v %v %v, got %v %v %v",
-				pingId, serverdataResponseValue, "\x00\x01\x00\x00", resp.packetId, resp.packetType, resp.packetBody)
-			return respBody, errors.New(msg)
+		err = expectPacket("ping", Packet{pingId, ServerdataResponseValue, "\x00\x01\x00\x00"}, resp)
+		if err != nil {
+			return respBody.String(), err
 		}
 	}
 	// Check if socket is still open for reading
-	{
-		// Send check packet
-		checkId := conn.counter()
-		checkPacket := packet{
-			packetId:   checkId,
-			packetType: serverdataResponseValue,
-			packetBody: "",
-		}
-		err := conn.client.sendPacket(checkPacket)
-		if err != nil {
-			return respBody, err
-		}
-		// Receive empty check packet and check for expectation
-		resp, err := conn.client.receivePacket()
-		if err != nil {
-			return respBody, err
-		}
-		if (resp != packet{checkId, serverdataResponseValue, ""}) {
-			msg := fmt.Sprintf("received unexpected response (check); expected %v %v %v, got %v %v %v",
-				checkId, serverdataResponseValue, "", resp.packetId, resp.packetType, resp.packetBody)
-			return respBody, errors.New(msg)
-		}
-		// Receive check packet with body 0x00010000 and check for expectation
-		resp, err = conn.client.receivePacket()
-		if err != nil {
-			return respBody, err
-		}
-		if (resp != packet{checkId, serverdataResponseValue, "\x00\x01\x00\x00"}) {
-			msg := fmt.Sprintf("received unexpected response (check); expected %v %v %v, got %v %v %v",
-				checkId, serverdataResponseValue, "\x00\x01\x00\x00", resp.packetId, resp.packetType, resp.packetBody)
-			return respBody, errors.New(msg)
-		}
+	err = check(ctx, c, checkId)
+	if err != nil {
+		return respBody.String(), err
 	}
-	return respBody, nil
+	return respBody.String(), nil
 }
 
-func (conn *RCONConnection) counter() int {
-	conn.idCounter++
-	return conn.idCounter
+// Ping checks that the server is still responding, in the manner of the connection's dialect; for Source servers, by
+// sending the same empty SERVERDATA_RESPONSE_VALUE check packet that follows every command. It returns a non-nil
+// error if the server fails to answer as expected.
+func (conn *RCONConnection) Ping(ctx context.Context) error {
+	sess, err := conn.session(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := conn.opts.commandContext(ctx)
+	defer cancel()
+	return conn.opts.dialect.Ping(ctx, &Session{sess})
 }
 
+// check sends an empty SERVERDATA_RESPONSE_VALUE packet with ID checkId as part of call c, and checks that the server
+// mirrors it and follows it with the 0x00010000 response.
+func check(ctx context.Context, c *Call, checkId int) error {
+	// Send check packet
+	checkPacket := Packet{
+		ID:   checkId,
+		Type: ServerdataResponseValue,
+		Body: "",
+	}
+	err := c.Send(ctx, checkPacket)
+	if err != nil {
+		return err
+	}
+	// Receive empty check packet and check for expectation
+	resp, err := c.Receive(ctx)
+	if err != nil {
+		return err
+	}
+	err = expectPacket("check", Packet{checkId, ServerdataResponseValue, ""}, resp)
+	if err != nil {
+		return err
+	}
+	// Receive check packet with body 0x00010000 and check for expectation
+	resp, err = c.Receive(ctx)
+	if err != nil {
+		return err
+	}
+	return expectPacket("check", Packet{checkId, ServerdataResponseValue, "\x00\x01\x00\x00"}, resp)
+}
+
+// session returns the session to send commands on, first replacing it if it is broken and reconnection is enabled.
+// A broken session is otherwise returned as is, and reports its own failure when used.
+func (conn *RCONConnection) session(ctx context.Context) (*session, error) {
+	conn.mu.Lock()
+	sess, closed := conn.sess, conn.closed
+	conn.mu.Unlock()
+	if closed || sess == nil {
+		return nil, errClosed
+	}
+	if !sess.broken() || !conn.canReconnect() {
+		return sess, nil
+	}
+	return conn.reconnect(ctx, sess)
+}
+
+// usable reports whether the connection can still send commands, or at least reconnect in order to do so.
+func (conn *RCONConnection) usable() bool {
+	conn.mu.Lock()
+	sess, closed := conn.sess, conn.closed
+	conn.mu.Unlock()
+	if closed || sess == nil {
+		return false
+	}
+	return !sess.broken() || conn.canReconnect()
+}
+
+// Close closes the connection. Commands in flight on other goroutines return with an error.
 func (conn *RCONConnection) Close() {
-	if conn.client == nil {
+	conn.mu.Lock()
+	sess, closed := conn.sess, conn.closed
+	conn.closed = true
+	conn.mu.Unlock()
+	if closed || sess == nil {
 		return
 	}
-	conn.client.close()
+	sess.close()
 }
 
 // AuthenticationFailure is an error type which indicates that an RCONConnection failed to authenticate. It is intended
-// to be caught and handled as a recoverable error.
-type AuthenticationFailure struct{}
+// to be caught and handled as a recoverable error. It is returned as a pointer, so should be checked for with errors.As
+// and a target of type *AuthenticationFailure. It wraps what the server said to reject the password, if anything.
+type AuthenticationFailure struct {
+	err error
+}
 
 func (e AuthenticationFailure) Error() string {
+	if e.err != nil {
+		return "Failed to make connection: authentication failure: " + e.err.Error()
+	}
 	return "Failed to make connection: authentication failure"
 }
+
+func (e AuthenticationFailure) Unwrap() error {
+	return e.err
+}
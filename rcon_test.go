@@ -0,0 +1,286 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPassword is the password accepted by serveEcho.
+const testPassword = "password"
+
+// serveEcho answers authentication and commands sent over con the way SRCDS does, replying to "echo x" with "x",
+// stalling for a while on the command "hang" and dropping the connection on "quit". It returns once con is closed.
+func serveEcho(con net.Conn) {
+	serve(con, func(cmd string) string {
+		return strings.TrimPrefix(cmd, "echo ")
+	})
+}
+
+// serve is like serveEcho, but replies to commands other than "hang" and "quit" with the output of handle.
+func serve(con net.Conn, handle func(cmd string) string) {
+	server := client{con: con}
+	// Read ahead of processing, as the send buffer of a TCP socket would let the client do
+	requests := make(chan Packet, 64)
+	go func() {
+		defer close(requests)
+		for {
+			p, err := server.receivePacket()
+			if err != nil {
+				return
+			}
+			requests <- p
+		}
+	}()
+	for p := range requests {
+		var replies []Packet
+		switch p.Type {
+		case ServerdataAuth:
+			authId := p.ID
+			if p.Body != testPassword {
+				authId = -1
+			}
+			replies = append(replies, Packet{p.ID, ServerdataResponseValue, ""},
+				Packet{authId, ServerdataAuthResponse, ""})
+		case ServerdataExeccommand:
+			if p.Body == "hang" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			if p.Body == "quit" {
+				_ = con.Close()
+				return
+			}
+			replies = append(replies, Packet{p.ID, ServerdataResponseValue, handle(p.Body)})
+		case ServerdataResponseValue:
+			replies = append(replies, Packet{p.ID, ServerdataResponseValue, ""},
+				Packet{p.ID, ServerdataResponseValue, "\x00\x01\x00\x00"})
+		}
+		for _, r := range replies {
+			if server.sendPacket(r) != nil {
+				return
+			}
+		}
+	}
+}
+
+// newEchoConnection returns an RCONConnection talking to serveEcho over an in-memory pipe.
+func newEchoConnection(t *testing.T, opts ...Option) *RCONConnection {
+	serverCon, clientCon := net.Pipe()
+	go serveEcho(serverCon)
+	conn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword, opts...)
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	return conn
+}
+
+func TestSendCommandConcurrent(t *testing.T) {
+	conn := newEchoConnection(t)
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := strconv.Itoa(i)
+			got, err := conn.SendCommand("echo " + want)
+			if err != nil {
+				t.Errorf("Encountered error while sending command %v: %v", i, err)
+			}
+			if got != want {
+				t.Errorf("Command %v, expected response %q, got %q", i, want, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSendCommandCancel(t *testing.T) {
+	conn := newEchoConnection(t)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := conn.SendCommandContext(ctx, "hang")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v from unanswered command, got %v", context.DeadlineExceeded, err)
+	}
+
+	// The connection should still be usable after an abandoned command
+	got, err := conn.SendCommand("echo after")
+	if err != nil {
+		t.Errorf("Encountered error while sending command after cancellation: %v", err)
+	}
+	if got != "after" {
+		t.Errorf("Expected response %q after cancellation, got %q", "after", got)
+	}
+}
+
+func TestSendCommandClosed(t *testing.T) {
+	conn := newEchoConnection(t)
+	conn.Close()
+	if _, err := conn.SendCommand("echo closed"); err == nil {
+		t.Errorf("Expected error sending command on closed connection")
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	conn := newEchoConnection(t, WithCommandTimeout(50*time.Millisecond))
+	defer conn.Close()
+
+	_, err := conn.SendCommand("hang")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v from command overrunning its timeout, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestDialFunc(t *testing.T) {
+	dialErr := errors.New("no route")
+	var dialed string
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		return nil, dialErr
+	}
+	_, err := NewRCONConnection("example.com", 27015, "password", WithDialFunc(dial))
+	if !errors.Is(err, dialErr) {
+		t.Errorf("Expected error from dial function, got %v", err)
+	}
+	if dialed != "example.com:27015" {
+		t.Errorf("Expected dial to %v, got %v", "example.com:27015", dialed)
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	serverCon, clientCon := net.Pipe()
+	defer serverCon.Close()
+	go serveEcho(serverCon)
+	_, err := NewRCONConnectionFromConn(context.Background(), clientCon, "wrong")
+	if _, ok := err.(*AuthenticationFailure); !ok {
+		t.Errorf("Expected authentication failure, got %v", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var events []ReconnectEvent
+	policy := ReconnectPolicy{
+		Idempotent: func(cmd string) bool {
+			return cmd != "quit"
+		},
+		OnReconnect: func(event ReconnectEvent) {
+			events = append(events, event)
+		},
+	}
+	conn := newEchoConnection(t, WithReconnect(policy))
+	defer conn.Close()
+	conn.redial = func(ctx context.Context) (*client, error) {
+		serverCon, clientCon := net.Pipe()
+		go serveEcho(serverCon)
+		c := &client{con: clientCon}
+		return c, authenticateClient(ctx, c, testPassword, conn.opts)
+	}
+
+	if _, err := conn.SendCommand("quit"); err == nil {
+		t.Errorf("Expected error from command dropping the connection")
+	}
+	got, err := conn.SendCommand("echo again")
+	if err != nil {
+		t.Errorf("Encountered error while sending command after reconnecting: %v", err)
+	}
+	if got != "again" {
+		t.Errorf("Expected response %q after reconnecting, got %q", "again", got)
+	}
+	if len(events) != 1 || events[0].Err != nil || events[0].Cause == nil {
+		t.Errorf("Expected one successful reconnection event, got %v", events)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	p := ReconnectPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("Attempt %v, expected backoff %v, got %v", i+1, w, got)
+		}
+	}
+}
+
+func BenchmarkSendCommand(b *testing.B) {
+	serverCon, clientCon := net.Pipe()
+	go serveEcho(serverCon)
+	conn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword)
+	if err != nil {
+		b.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.SendCommand("echo benchmark"); err != nil {
+			b.Fatalf("Encountered error while sending command: %v", err)
+		}
+	}
+}
+
+func TestInvalidAddress(t *testing.T) {
+	constructors := map[string]func(host string, port int) error{
+		"source": func(host string, port int) error {
+			_, err := NewRCONConnection(host, port, testPassword)
+			return err
+		},
+		"conn": func(host string, port int) error {
+			_, err := DialConn(context.Background(), host, port)
+			return err
+		},
+		"battleye": func(host string, port int) error {
+			_, err := NewBattlEyeConnection(host, port, testPassword)
+			return err
+		},
+		"webrcon": func(host string, port int) error {
+			_, err := NewWebRCONConnection(host, port, testPassword)
+			return err
+		},
+		"goldsrc": func(host string, port int) error {
+			_, err := NewGoldSrcConnection(host, port, testPassword)
+			return err
+		},
+	}
+	cases := []struct {
+		host string
+		port int
+	}{
+		{"", 27015},
+		{"127.0.0.1", 0},
+		{"127.0.0.1", 65536},
+	}
+	for name, connect := range constructors {
+		for _, c := range cases {
+			err := connect(c.host, c.port)
+			if err == nil || errors.As(err, new(ConnectionFailure)) {
+				t.Errorf("Protocol %v, connecting to %q port %v, expected argument error, got %v", name, c.host,
+					c.port, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+// Package rcontest provides a scriptable fake RCON server, for testing code which talks to game servers through the
+// rcon package.
+//
+// The server is an rcon.Server, so it speaks the Source RCON protocol as SRCDS does. Tests tell it which commands to
+// expect and how to answer each one: with canned output, after a delay, by dropping the connection, or with raw bytes
+// such as a malformed packet. Clients connect to it over a loopback listener, with the Host and Port of the server, or
+// over an in-memory pipe, with Pipe and rcon.NewRCONConnectionFromConn.
+package rcontest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vibeisveryo/rcon"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Server is a fake RCON server. It should be created with NewServer, and closed once the test is over. Its methods
+// are safe for concurrent use by multiple goroutines.
+type Server struct {
+	password string
+	listener net.Listener
+	server   *rcon.Server
+
+	// wg tracks the goroutines serving the listener and pipes
+	wg sync.WaitGroup
+
+	// mu guards the fields below, as well as the fields of every Expectation
+	mu           sync.Mutex
+	closed       bool
+	expectations []*Expectation
+	commands     []string
+	unexpected   []string
+}
+
+// An Expectation is a command the server expects to receive, and the way it answers it; by default, with empty output.
+// Its methods return the Expectation itself, so that they can be chained, and should be called before the command is
+// sent.
+type Expectation struct {
+	server  *Server
+	command string
+	matched bool
+
+	output     string
+	delay      time.Duration
+	disconnect bool
+	raw        []byte
+}
+
+// NewServer starts a fake server listening on a loopback port, accepting the given password. It panics if it cannot
+// listen.
+func NewServer(password string) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("rcontest: failed to listen on a port: %v", err))
+	}
+	s := &Server{
+		password: password,
+		listener: listener,
+	}
+	// Commands are only limited in length by what a test sends
+	s.server = rcon.NewServer(s.authenticate, handler{s}, rcon.WithMaxPacketSize(1<<24))
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		_ = s.server.Serve(listener)
+	}()
+	return s
+}
+
+// Host returns the address the server listens on, for use with rcon.NewRCONConnection.
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server listens on, for use with rcon.NewRCONConnection.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Pipe returns the client end of an in-memory connection to the server, for use with
+// rcon.NewRCONConnectionFromConn. The connection is closed, if it has not been already, when the server is.
+func (s *Server) Pipe() net.Conn {
+	serverCon, clientCon := net.Pipe()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		_ = serverCon.Close()
+		return clientCon
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		_ = s.server.ServeConn(context.Background(), serverCon)
+	}()
+	return clientCon
+}
+
+// Expect adds cmd to the commands the server expects to receive. If the same command is expected more than once, each
+// Expectation answers one occurrence, in the order they were added. Commands received without a matching Expectation
+// are answered with an error message, in the way SRCDS answers unknown commands, and reported by Verify.
+func (s *Server) Expect(cmd string) *Expectation {
+	e := &Expectation{server: s, command: cmd}
+	s.mu.Lock()
+	s.expectations = append(s.expectations, e)
+	s.mu.Unlock()
+	return e
+}
+
+// Respond sets the output the command is answered with. Output longer than 4096 bytes is split over several packets.
+func (e *Expectation) Respond(output string) *Expectation {
+	e.server.mu.Lock()
+	e.output = output
+	e.server.mu.Unlock()
+	return e
+}
+
+// Delay makes the server wait for d before answering the command, as a server busy with a slow command does. Nothing
+// else is answered on the connection in the meantime.
+func (e *Expectation) Delay(d time.Duration) *Expectation {
+	e.server.mu.Lock()
+	e.delay = d
+	e.server.mu.Unlock()
+	return e
+}
+
+// Disconnect makes the server drop the connection instead of answering the command, as a server which crashes or
+// restarts does.
+func (e *Expectation) Disconnect() *Expectation {
+	e.server.mu.Lock()
+	e.disconnect = true
+	e.server.mu.Unlock()
+	return e
+}
+
+// RespondRaw makes the server write data to the connection in place of the packets answering the command, such as a
+// malformed packet. The server goes on answering whatever follows the command as usual.
+func (e *Expectation) RespondRaw(data []byte) *Expectation {
+	e.server.mu.Lock()
+	e.raw = append([]byte(nil), data...)
+	e.server.mu.Unlock()
+	return e
+}
+
+// Commands returns every command the server has received so far, from all connections, in the order received.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Verify returns an error describing every expected command which has not been received and every command received
+// which was not expected, or nil if there are none.
+func (s *Server) Verify() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, e := range s.expectations {
+		if !e.matched {
+			errs = append(errs, fmt.Errorf("expected command %q was not received", e.command))
+		}
+	}
+	for _, cmd := range s.unexpected {
+		errs = append(errs, fmt.Errorf("received unexpected command %q", cmd))
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the server, closing every connection to it, and waits for it to finish answering.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	_ = s.server.Close()
+	s.wg.Wait()
+}
+
+// authenticate accepts the password of the server, and no other.
+func (s *Server) authenticate(ctx context.Context, remote net.Addr, password string) (string, bool) {
+	return "", password == s.password
+}
+
+// A handler answers the commands received by a Server as its expectations say.
+type handler struct {
+	s *Server
+}
+
+func (h handler) ServeRCON(ctx context.Context, req *rcon.Request) string {
+	return h.ServeRCONResponse(ctx, req).Output
+}
+
+func (h handler) ServeRCONResponse(ctx context.Context, req *rcon.Request) rcon.Response {
+	e := h.s.match(req.Command)
+	if e.delay > 0 {
+		select {
+		case <-time.After(e.delay):
+		case <-ctx.Done():
+			return rcon.Response{Disconnect: true}
+		}
+	}
+	return rcon.Response{Output: e.output, Raw: e.raw, Disconnect: e.disconnect}
+}
+
+// match records the receipt of cmd and returns a copy of the first unmatched Expectation for it, or, if there is none,
+// one answering with an error message.
+func (s *Server) match(cmd string) Expectation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, cmd)
+	for _, e := range s.expectations {
+		if !e.matched && e.command == cmd {
+			e.matched = true
+			return *e
+		}
+	}
+	s.unexpected = append(s.unexpected, cmd)
+	return Expectation{output: fmt.Sprintf("Unknown command \"%s\"\n", cmd)}
+}
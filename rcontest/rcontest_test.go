@@ -0,0 +1,189 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcontest
+
+import (
+	"context"
+	"errors"
+	"github.com/vibeisveryo/rcon"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const password = "password"
+
+// login returns a Conn over a pipe to server, authenticated with the given password, failing the test on error.
+func login(t *testing.T, server *Server, password string) *rcon.Conn {
+	conn := rcon.NewConn(server.Pipe())
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	if err := conn.Authenticate(context.Background(), password); err != nil {
+		t.Fatalf("Encountered error while authenticating: %v", err)
+	}
+	return conn
+}
+
+// exchange sends cmd over conn and returns the body of the first packet answering it.
+func exchange(t *testing.T, conn *rcon.Conn, cmd string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := conn.WritePacket(ctx, rcon.Packet{ID: 5, Type: rcon.ServerdataExeccommand, Body: cmd}); err != nil {
+		t.Fatalf("Encountered error while sending command %q: %v", cmd, err)
+	}
+	p, err := conn.ReadPacket(ctx)
+	if err != nil {
+		return "", err
+	}
+	if p.ID != 5 || p.Type != rcon.ServerdataResponseValue {
+		t.Errorf("Command %q, expected response to packet 5, got %+v", cmd, p)
+	}
+	return p.Body, nil
+}
+
+func TestWrongPassword(t *testing.T) {
+	server := NewServer(password)
+	defer server.Close()
+
+	conn := rcon.NewConn(server.Pipe())
+	defer conn.Close()
+	err := conn.Authenticate(context.Background(), "wrong")
+	var authFailure *rcon.AuthenticationFailure
+	if !errors.As(err, &authFailure) {
+		t.Errorf("Expected authentication failure for wrong password, got %v", err)
+	}
+}
+
+func TestUnexpectedCommand(t *testing.T) {
+	server := NewServer(password)
+	defer server.Close()
+	server.Expect("status").Respond("hostname: test\n")
+	server.Expect("users")
+	conn := login(t, server, password)
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"status", "hostname: test\n"},
+		{"status", "Unknown command \"status\"\n"},
+		{"nonsense", "Unknown command \"nonsense\"\n"},
+	}
+	for _, c := range cases {
+		got, err := exchange(t, conn, c.in)
+		if err != nil {
+			t.Errorf("Encountered error while sending command %q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Command %q, expected %q, got %q", c.in, c.want, got)
+		}
+	}
+
+	err := server.Verify()
+	for _, want := range []string{`expected command "users"`, `unexpected command "status"`,
+		`unexpected command "nonsense"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q to be reported, got %v", want, err)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	server := NewServer(password)
+	defer server.Close()
+	server.Expect("slow").Delay(100 * time.Millisecond).Respond("late")
+	conn := login(t, server, password)
+
+	start := time.Now()
+	got, err := exchange(t, conn, "slow")
+	if err != nil || got != "late" {
+		t.Errorf("Expected %q, got %q and error %v", "late", got, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected response after at least 100ms, got it after %v", elapsed)
+	}
+
+	// Closing the server cuts a delay short
+	server.Expect("slower").Delay(time.Minute)
+	closed := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.Close()
+		close(closed)
+	}()
+	if _, err := exchange(t, conn, "slower"); err == nil {
+		t.Errorf("Expected error once server closed")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for server to close")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	server := NewServer(password)
+	defer server.Close()
+	server.Expect("crash").Disconnect()
+	conn := login(t, server, password)
+
+	if _, err := exchange(t, conn, "crash"); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected %v, got %v", io.EOF, err)
+	}
+	// Other connections are unaffected
+	if got, err := exchange(t, login(t, server, password), "other"); err != nil || got == "" {
+		t.Errorf("Expected response on another connection, got %q and error %v", got, err)
+	}
+}
+
+func TestMalformedPacket(t *testing.T) {
+	server := NewServer(password)
+	defer server.Close()
+	// A packet too short to hold an ID and a type is written in place of the response
+	server.Expect("status").RespondRaw([]byte{4, 0, 0, 0})
+	conn := login(t, server, password)
+
+	if _, err := exchange(t, conn, "status"); !errors.Is(err, rcon.ErrPacketTruncated) {
+		t.Errorf("Expected %v, got %v", rcon.ErrPacketTruncated, err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	server := NewServer(password)
+	server.Expect("status").Respond("hostname: test\n")
+
+	conn, err := rcon.NewRCONConnection(server.Host(), server.Port(), password)
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+	if got, err := conn.SendCommand("status"); err != nil || got != "hostname: test\n" {
+		t.Errorf("Expected %q, got %q and error %v", "hostname: test\n", got, err)
+	}
+
+	// Closing the server closes the listener, and pipes opened afterwards
+	server.Close()
+	if _, err := rcon.NewRCONConnection(server.Host(), server.Port(), password); err == nil {
+		t.Errorf("Expected error connecting to closed server")
+	}
+	pipe := server.Pipe()
+	if _, err := pipe.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected error reading from pipe to closed server")
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/vibeisveryo/rcon"
+	"github.com/vibeisveryo/rcon/rcontest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const password = "password"
+
+// dial connects to server over a loopback connection, failing the test on error.
+func dial(t *testing.T, server *rcontest.Server, opts ...rcon.Option) *rcon.RCONConnection {
+	conn, err := rcon.NewRCONConnection(server.Host(), server.Port(), password, opts...)
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	return conn
+}
+
+func TestNewRCONConnection(t *testing.T) {
+	server := rcontest.NewServer(password)
+	defer server.Close()
+
+	conn := dial(t, server)
+	conn.Close()
+
+	_, err := rcon.NewRCONConnection(server.Host(), server.Port(), "wrong")
+	var authFailure *rcon.AuthenticationFailure
+	if !errors.As(err, &authFailure) {
+		t.Errorf("Expected authentication failure for wrong password, got %v", err)
+	}
+
+	cases := []struct {
+		host string
+		port int
+	}{
+		{"", server.Port()},
+		{server.Host(), 0},
+		{server.Host(), 65536},
+	}
+	for _, c := range cases {
+		if _, err := rcon.NewRCONConnection(c.host, c.port, password); err == nil {
+			t.Errorf("Connecting to %q port %v, expected error", c.host, c.port)
+		}
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	server := rcontest.NewServer(password)
+	defer server.Close()
+	long := strings.Repeat("player\n", 2000)
+	server.Expect("status").Respond("hostname: test\n")
+	server.Expect("users").Respond(long)
+	server.Expect("status").Respond("hostname: changed\n")
+
+	conn := dial(t, server)
+	defer conn.Close()
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"status", "hostname: test\n"},
+		{"users", long},
+		{"status", "hostname: changed\n"},
+		{"nonsense", "Unknown command \"nonsense\"\n"},
+	}
+	for _, c := range cases {
+		got, err := conn.SendCommand(c.in)
+		if err != nil {
+			t.Errorf("Encountered error while sending command %q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Sent command %q, expected response of length %v, got %v", c.in, len(c.want), len(got))
+		}
+	}
+
+	if want := []string{"status", "users", "status", "nonsense"}; !reflect.DeepEqual(server.Commands(), want) {
+		t.Errorf("Expected commands %q, got %q", want, server.Commands())
+	}
+	err := server.Verify()
+	if err == nil || !strings.Contains(err.Error(), `"nonsense"`) {
+		t.Errorf("Expected unexpected command to be reported, got %v", err)
+	}
+}
+
+func TestSendCommandPipe(t *testing.T) {
+	server := rcontest.NewServer(password)
+	defer server.Close()
+	server.Expect("echo hi").Respond("hi")
+
+	conn, err := rcon.NewRCONConnectionFromConn(context.Background(), server.Pipe(), password)
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+	if got, err := conn.SendCommand("echo hi"); err != nil || got != "hi" {
+		t.Errorf("Expected response %q, got %q and error %v", "hi", got, err)
+	}
+	if err := server.Verify(); err != nil {
+		t.Errorf("Encountered error while verifying: %v", err)
+	}
+}
+
+func TestSendCommandDelay(t *testing.T) {
+	server := rcontest.NewServer(password)
+	defer server.Close()
+	server.Expect("slow").Delay(200 * time.Millisecond).Respond("late")
+	server.Expect("fast").Respond("on time")
+
+	conn := dial(t, server, rcon.WithCommandTimeout(50*time.Millisecond))
+	defer conn.Close()
+	if _, err := conn.SendCommand("slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v for delayed command, got %v", context.DeadlineExceeded, err)
+	}
+	// The late response is discarded, and the connection remains usable once the server catches up
+	time.Sleep(200 * time.Millisecond)
+	if got, err := conn.SendCommand("fast"); err != nil || got != "on time" {
+		t.Errorf("Expected response %q, got %q and error %v", "on time", got, err)
+	}
+}
+
+func TestSendCommandDisconnect(t *testing.T) {
+	server := rcontest.NewServer(password)
+	defer server.Close()
+	server.Expect("crash").Disconnect()
+	server.Expect("status").Disconnect()
+	server.Expect("status").Respond("back up")
+
+	conn := dial(t, server, rcon.WithReconnect(rcon.ReconnectPolicy{
+		Idempotent: func(cmd string) bool {
+			return cmd == "status"
+		},
+	}))
+	defer conn.Close()
+	if _, err := conn.SendCommand("crash"); err == nil {
+		t.Errorf("Expected error for command answered by disconnecting")
+	}
+	// Reconnects for the next command, then retries it once more when the connection drops again
+	if got, err := conn.SendCommand("status"); err != nil || got != "back up" {
+		t.Errorf("Expected response %q, got %q and error %v", "back up", got, err)
+	}
+	if err := server.Verify(); err != nil {
+		t.Errorf("Encountered error while verifying: %v", err)
+	}
+}
+
+func TestSendCommandMalformed(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  []byte
+		want error
+	}{
+		{"unterminated", append(binary.LittleEndian.AppendUint32(nil, 10), 1, 0, 0, 0, 0, 0, 0, 0, 'x', 'x'),
+			rcon.ErrPacketNotTerminated},
+		{"oversized", binary.LittleEndian.AppendUint32(nil, 1<<30), rcon.ErrPacketOversized},
+		{"truncated", binary.LittleEndian.AppendUint32(nil, 4), rcon.ErrPacketTruncated},
+	}
+	for _, c := range cases {
+		server := rcontest.NewServer(password)
+		server.Expect("status").RespondRaw(c.raw)
+		conn := dial(t, server)
+		if _, err := conn.SendCommand("status"); !errors.Is(err, c.want) {
+			t.Errorf("Receiving %v packet, expected %v, got %v", c.name, c.want, err)
+		}
+		conn.Close()
+		server.Close()
+	}
+}
+
+// markerDialect is a Dialect implemented outside the package, which takes the first packet mirrored back after a
+// command to mark the end of its response.
+type markerDialect struct{}
+
+func (markerDialect) Name() string {
+	return "marker"
+}
+
+func (markerDialect) Authenticate(ctx context.Context, conn *rcon.Conn, password string) error {
+	err := conn.WritePacket(ctx, rcon.Packet{ID: 1, Type: rcon.ServerdataAuth, Body: password})
+	if err != nil {
+		return err
+	}
+	for {
+		p, err := conn.ReadPacket(ctx)
+		if err != nil {
+			return err
+		}
+		if p.Type != rcon.ServerdataAuthResponse {
+			continue
+		}
+		if p.ID != 1 {
+			return new(rcon.AuthenticationFailure)
+		}
+		return nil
+	}
+}
+
+func (markerDialect) SendCommand(ctx context.Context, sess *rcon.Session, cmd string) (string, error) {
+	c, err := sess.StartCall(2)
+	if err != nil {
+		return "", err
+	}
+	defer c.End()
+	ids := c.IDs()
+	err = c.Send(ctx, rcon.Packet{ID: ids[0], Type: rcon.ServerdataExeccommand, Body: cmd},
+		rcon.Packet{ID: ids[1], Type: rcon.ServerdataResponseValue})
+	if err != nil {
+		return "", err
+	}
+	var output strings.Builder
+	for {
+		p, err := c.Receive(ctx)
+		if err != nil {
+			return output.String(), err
+		}
+		if p.ID == ids[1] {
+			return output.String(), nil
+		}
+		output.WriteString(p.Body)
+	}
+}
+
+func (d markerDialect) Ping(ctx context.Context, sess *rcon.Session) error {
+	_, err := d.SendCommand(ctx, sess, "")
+	return err
+}
+
+func (markerDialect) MaxPacketSize() int {
+	return 0
+}
+
+func TestCustomDialect(t *testing.T) {
+	server := rcontest.NewServer(password)
+	defer server.Close()
+	long := strings.Repeat("player\n", 2000)
+	server.Expect("status").Respond("hostname: test\n")
+	server.Expect("users").Respond(long)
+	server.Expect("").Respond("")
+
+	conn := dial(t, server, rcon.WithDialect(markerDialect{}))
+	defer conn.Close()
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"status", "hostname: test\n"},
+		{"users", long},
+	}
+	for _, c := range cases {
+		got, err := conn.SendCommand(c.in)
+		if err != nil {
+			t.Errorf("Encountered error while sending command %q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Command %q, expected response of %v bytes, got %v", c.in, len(c.want), len(got))
+		}
+	}
+	if err := conn.Ping(context.Background()); err != nil {
+		t.Errorf("Encountered error while pinging: %v", err)
+	}
+
+	_, err := rcon.NewRCONConnection(server.Host(), server.Port(), "wrong", rcon.WithDialect(markerDialect{}))
+	var authFailure *rcon.AuthenticationFailure
+	if !errors.As(err, &authFailure) {
+		t.Errorf("Expected authentication failure for wrong password, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"time"
+)
+
+// Defaults for the zero values of ReconnectPolicy fields.
+const (
+	defaultReconnectAttempts = 5
+	defaultMinBackoff        = 500 * time.Millisecond
+	defaultMaxBackoff        = 30 * time.Second
+)
+
+// A ReconnectPolicy describes how an RCONConnection recovers when its connection to the server drops, as happens when
+// a game server restarts on map change or crash. The zero value is a usable policy which never retries commands.
+type ReconnectPolicy struct {
+	// MaxAttempts is how many times to try redialing and re-authenticating before giving up and returning the error
+	// from the last attempt. If zero, 5 attempts are made.
+	MaxAttempts int
+
+	// MinBackoff is the wait before the second attempt; each following wait doubles, up to MaxBackoff. The first
+	// attempt is made immediately. If zero, they default to 500 milliseconds and 30 seconds respectively.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Idempotent reports whether cmd can safely be sent a second time if the connection drops before its response is
+	// received, in which case the command may or may not already have run. If nil, no command is retried; the
+	// connection is re-established for the next command instead.
+	Idempotent func(cmd string) bool
+
+	// OnReconnect, if not nil, is called after every reconnection attempt. It is called synchronously from the
+	// goroutine which found the connection broken, so it should return promptly.
+	OnReconnect func(event ReconnectEvent)
+}
+
+// A ReconnectEvent reports the outcome of an attempt to re-establish a dropped connection.
+type ReconnectEvent struct {
+	// Attempt counts the attempts made so far to replace the dropped connection, starting at 1.
+	Attempt int
+	// Cause is the error with which the dropped connection failed.
+	Cause error
+	// Err is nil if the attempt succeeded, or otherwise the reason it failed.
+	Err error
+}
+
+// WithReconnect makes the connection re-establish itself according to p when it drops, re-authenticating with the
+// password it was created with. Without this option, a dropped connection fails every subsequent command.
+func WithReconnect(p ReconnectPolicy) Option {
+	return func(o *options) {
+		o.reconnect = &p
+	}
+}
+
+// idempotent reports whether the policy allows cmd to be retried; it is safe to call on a nil policy.
+func (p *ReconnectPolicy) idempotent(cmd string) bool {
+	return p != nil && p.Idempotent != nil && p.Idempotent(cmd)
+}
+
+// backoff returns how long to wait before the given attempt, counting from 1.
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	minBackoff, maxBackoff := p.MinBackoff, p.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	wait := minBackoff
+	for i := 2; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// canReconnect reports whether the connection is able to replace a broken session.
+func (conn *RCONConnection) canReconnect() bool {
+	return conn.opts.reconnect != nil && conn.redial != nil
+}
+
+// reconnect replaces the broken session old with a freshly dialed one, retrying as the policy allows. Concurrent
+// callers wait for a single reconnection rather than each dialing the server.
+func (conn *RCONConnection) reconnect(ctx context.Context, old *session) (*session, error) {
+	conn.reconnectMu.Lock()
+	defer conn.reconnectMu.Unlock()
+	// Another goroutine may have reconnected, or the connection been closed, while waiting for the lock
+	conn.mu.Lock()
+	sess, closed := conn.sess, conn.closed
+	conn.mu.Unlock()
+	if closed {
+		return nil, errClosed
+	}
+	if sess != old {
+		return sess, nil
+	}
+
+	p := conn.opts.reconnect
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultReconnectAttempts
+	}
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if wait := p.backoff(attempt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		var client *client
+		client, err = conn.redial(ctx)
+		if p.OnReconnect != nil {
+			p.OnReconnect(ReconnectEvent{Attempt: attempt, Cause: old.err, Err: err})
+		}
+		if err == nil {
+			sess = newSession(client)
+			conn.mu.Lock()
+			closed = conn.closed
+			if !closed {
+				conn.sess = sess
+			}
+			conn.mu.Unlock()
+			if closed {
+				sess.close()
+				return nil, errClosed
+			}
+			return sess, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
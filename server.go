@@ -0,0 +1,303 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxResponseBody is the longest body the server puts in a single response packet; longer responses are split over
+// several packets, as SRCDS does.
+const maxResponseBody = 4096
+
+// ErrServerClosed is returned by the Serve and ServeConn methods of a Server once it has been closed.
+var ErrServerClosed = errors.New("server closed")
+
+// A Request is a command received by a Server from an authenticated client.
+type Request struct {
+	// Command is the body of the SERVERDATA_EXECCOMMAND packet
+	Command string
+	// User is the name the Authenticator gave the client
+	User string
+	// RemoteAddr is the address of the client, or nil if the connection is not a net.Conn
+	RemoteAddr net.Addr
+}
+
+// A Handler responds to the commands received by a Server. ServeRCON is called for one command at a time on each
+// connection, but may be called concurrently for different connections. It returns the output of the command, which
+// may be empty. ctx is done once the connection is closed.
+type Handler interface {
+	ServeRCON(ctx context.Context, req *Request) string
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(ctx context.Context, req *Request) string
+
+func (f HandlerFunc) ServeRCON(ctx context.Context, req *Request) string {
+	return f(ctx, req)
+}
+
+// A Response is the answer of a ResponseHandler to a command.
+type Response struct {
+	// Output is the output of the command, sent in the same way as that returned by ServeRCON
+	Output string
+	// Raw, if not nil, is written to the connection as it is in place of the packets carrying Output, such as a
+	// malformed packet
+	Raw []byte
+	// Disconnect closes the connection instead of answering the command
+	Disconnect bool
+}
+
+// A ResponseHandler is a Handler with control over how each command is answered, for simulating misbehaving servers as
+// package rcontest does. If the Handler of a Server implements ResponseHandler, ServeRCONResponse is called in place of
+// ServeRCON.
+type ResponseHandler interface {
+	Handler
+	ServeRCONResponse(ctx context.Context, req *Request) Response
+}
+
+// An Authenticator decides whether a client may log in to a Server with the password it sent from the address remote,
+// which is nil if the connection is not a net.Conn. It returns the name the client goes by from then on, passed to the
+// Handler as Request.User, and false to turn the client away.
+type Authenticator func(ctx context.Context, remote net.Addr, password string) (user string, ok bool)
+
+// PasswordAuthenticator returns an Authenticator accepting the given password, and no other, under an empty user name.
+// The password is compared in constant time.
+func PasswordAuthenticator(password string) Authenticator {
+	want := []byte(password)
+	return func(ctx context.Context, remote net.Addr, password string) (string, bool) {
+		return "", subtle.ConstantTimeCompare([]byte(password), want) == 1
+	}
+}
+
+// A Server accepts RCON connections and answers them as SRCDS does, so that an RCONConnection with DialectSRCDS, and
+// other clients written for Source servers, can talk to it. Clients log in with SERVERDATA_AUTH, checked by an
+// Authenticator; the bodies of their SERVERDATA_EXECCOMMAND packets are passed to a Handler, whose output is sent back
+// split into packets of up to 4096 bytes; and empty SERVERDATA_RESPONSE_VALUE packets are mirrored, followed by a
+// packet with the body 0x00010000, so that clients can tell where the output of a command ends.
+//
+// A Server should be created with NewServer. Of the options, WithLogger and WithMaxPacketSize apply, the latter to
+// packets received from clients.
+type Server struct {
+	auth    Authenticator
+	handler Handler
+	opts    options
+
+	// ctx is cancelled when the server is closed, closing every connection
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wg tracks connections being served
+	wg sync.WaitGroup
+
+	// mu guards the fields below
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+// NewServer returns a Server checking passwords with auth and handing commands to handler. It does not listen until
+// Serve or ListenAndServe is called.
+func NewServer(auth Authenticator, handler Handler, opts ...Option) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		auth:      auth,
+		handler:   handler,
+		opts:      newOptions(opts),
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: make(map[net.Listener]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP network address, or on port 27015 if it is empty, and calls Serve to accept
+// connections on it.
+func (s *Server) ListenAndServe(address string) error {
+	if address == "" {
+		address = ":27015"
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l, serving each on a goroutine of its own, until l fails or the server is closed, in
+// which case it returns ErrServerClosed. l is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		_ = l.Close()
+	}()
+
+	for {
+		con, err := l.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.opts.logger.Debug("accept connection", "remote", con.RemoteAddr())
+		go func() {
+			_ = s.ServeConn(s.ctx, con)
+		}()
+	}
+}
+
+// ServeConn serves a single connection the caller has already established, such as one end of an in-process pipe,
+// until the client disconnects, ctx is done, or the server is closed. con is closed when ServeConn returns. It
+// returns nil if the client disconnected, ctx.Err() if ctx is done, ErrServerClosed if the server was closed, and
+// otherwise the error which ended the connection, such as a *PacketSizeError.
+func (s *Server) ServeConn(ctx context.Context, con io.ReadWriteCloser) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = con.Close()
+		return ErrServerClosed
+	}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	// Closing the connection unblocks the read it is waiting on
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopServer := context.AfterFunc(s.ctx, cancel)
+	defer stopServer()
+	stopConn := context.AfterFunc(ctx, func() {
+		_ = con.Close()
+	})
+	defer stopConn()
+	defer con.Close()
+
+	err := s.serveConn(ctx, con)
+	switch {
+	case s.ctx.Err() != nil:
+		return ErrServerClosed
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, io.EOF):
+		return nil
+	}
+	return err
+}
+
+// Close stops the server: listeners passed to Serve are closed, every connection is closed, and Close waits for
+// handlers still running to return.
+func (s *Server) Close() error {
+	s.cancel()
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// serveConn answers the packets arriving on con one at a time, so that the responses to a command always precede
+// those to the packets sent after it. It returns the error which ended the connection.
+func (s *Server) serveConn(ctx context.Context, con io.ReadWriteCloser) error {
+	c := &client{con: con, log: s.opts.logger, maxSize: s.opts.packetSizeLimit()}
+	var remote net.Addr
+	if netCon, ok := con.(net.Conn); ok {
+		remote = netCon.RemoteAddr()
+	}
+	var user string
+	authenticated := false
+	for {
+		p, err := c.receivePacket()
+		if err != nil {
+			return err
+		}
+		var replies []Packet
+		switch p.Type {
+		case ServerdataAuth:
+			// The authentication response carries the ID of the request, or -1 if the password is wrong
+			authId := -1
+			if name, ok := s.auth(ctx, remote, p.Body); ok {
+				user, authenticated, authId = name, true, p.ID
+			} else {
+				s.opts.logger.Info("authentication failed", "remote", remote)
+			}
+			replies = append(replies, Packet{p.ID, ServerdataResponseValue, ""},
+				Packet{authId, ServerdataAuthResponse, ""})
+		case ServerdataExeccommand:
+			if !authenticated {
+				return errors.New("command sent before authentication")
+			}
+			req := &Request{Command: p.Body, User: user, RemoteAddr: remote}
+			rh, ok := s.handler.(ResponseHandler)
+			if !ok {
+				replies = splitResponse(p.ID, s.handler.ServeRCON(ctx, req))
+				break
+			}
+			resp := rh.ServeRCONResponse(ctx, req)
+			if resp.Disconnect {
+				return io.EOF
+			}
+			if resp.Raw != nil {
+				if _, err := con.Write(resp.Raw); err != nil {
+					return err
+				}
+				continue
+			}
+			replies = splitResponse(p.ID, resp.Output)
+		case ServerdataResponseValue:
+			if !authenticated {
+				return errors.New("command sent before authentication")
+			}
+			replies = append(replies, Packet{p.ID, ServerdataResponseValue, ""},
+				Packet{p.ID, ServerdataResponseValue, "\x00\x01\x00\x00"})
+		default:
+			c.logger().Debug("ignore packet of unknown type", "packet", p)
+			continue
+		}
+		err = c.sendPackets(replies...)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitResponse returns the SERVERDATA_RESPONSE_VALUE packets carrying output in answer to the command with the given
+// ID: one per 4096 bytes, or a single empty one if there is no output.
+func splitResponse(id int, output string) []Packet {
+	packets := make([]Packet, 0, len(output)/maxResponseBody+1)
+	for len(output) > maxResponseBody {
+		packets = append(packets, Packet{id, ServerdataResponseValue, output[:maxResponseBody]})
+		output = output[maxResponseBody:]
+	}
+	return append(packets, Packet{id, ServerdataResponseValue, output})
+}
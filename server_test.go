@@ -0,0 +1,216 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer runs a Server on a local port, answering "whoami" with the user, "long" with output spanning several
+// packets, and echoing any other command; it is closed when the test ends.
+func startServer(t *testing.T) (*Server, int) {
+	auth := func(ctx context.Context, remote net.Addr, password string) (string, bool) {
+		if remote == nil {
+			return "", false
+		}
+		return "admin", password == testPassword
+	}
+	handler := HandlerFunc(func(ctx context.Context, req *Request) string {
+		switch req.Command {
+		case "whoami":
+			return req.User
+		case "long":
+			return strings.Repeat("x", 3*maxResponseBody+1)
+		}
+		return req.Command
+	})
+	server := NewServer(auth, handler)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Encountered error while listening: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Expected serving to end with %v, got %v", ErrServerClosed, err)
+		}
+	})
+	return server, listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestServer(t *testing.T) {
+	_, port := startServer(t)
+	conn, err := NewRCONConnection("127.0.0.1", port, testPassword)
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"echo", "echo"},
+		{"whoami", "admin"},
+		{"", ""},
+		{"long", strings.Repeat("x", 3*maxResponseBody+1)},
+	}
+	for _, c := range cases {
+		got, err := conn.SendCommand(c.in)
+		if err != nil {
+			t.Errorf("Encountered error while sending command %q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Sent command %q, expected response of length %v, got %v", c.in, len(c.want), len(got))
+		}
+	}
+	if err := conn.Ping(context.Background()); err != nil {
+		t.Errorf("Encountered error while pinging: %v", err)
+	}
+
+	_, err = NewRCONConnection("127.0.0.1", port, "wrong")
+	var authFailure *AuthenticationFailure
+	if !errors.As(err, &authFailure) {
+		t.Errorf("Expected authentication failure for wrong password, got %v", err)
+	}
+}
+
+func TestSplitResponse(t *testing.T) {
+	cases := []struct {
+		in   int
+		want []int
+	}{
+		{0, []int{0}},
+		{maxResponseBody, []int{maxResponseBody}},
+		{maxResponseBody + 1, []int{maxResponseBody, 1}},
+		{2 * maxResponseBody, []int{maxResponseBody, maxResponseBody}},
+	}
+	for _, c := range cases {
+		packets := splitResponse(7, strings.Repeat("x", c.in))
+		var got []int
+		for _, p := range packets {
+			if p.ID != 7 || p.Type != ServerdataResponseValue {
+				t.Errorf("Splitting output of length %v, got unexpected packet %+v", c.in, p)
+			}
+			got = append(got, len(p.Body))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Splitting output of length %v, expected packets of lengths %v, got %v", c.in, c.want, got)
+		}
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	server := NewServer(PasswordAuthenticator(testPassword), HandlerFunc(func(ctx context.Context, req *Request) string {
+		return req.Command
+	}))
+	serverCon, clientCon := net.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeConn(context.Background(), serverCon)
+	}()
+
+	// A command before logging in ends the connection
+	conn := NewConn(clientCon)
+	if err := conn.WritePacket(context.Background(), Packet{1, ServerdataExeccommand, "early"}); err != nil {
+		t.Fatalf("Encountered error while writing packet: %v", err)
+	}
+	if err := <-served; err == nil {
+		t.Errorf("Expected error for command sent before authentication")
+	}
+	_ = conn.Close()
+
+	// Closing the server ends the connections it serves
+	serverCon, clientCon = net.Pipe()
+	go func() {
+		served <- server.ServeConn(context.Background(), serverCon)
+	}()
+	rconConn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword)
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer rconConn.Close()
+	_ = server.Close()
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected %v, got %v", ErrServerClosed, err)
+	}
+	if err := server.ServeConn(context.Background(), serverCon); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serving after close, expected %v, got %v", ErrServerClosed, err)
+	}
+}
+
+// faultHandler answers "raw" with a malformed packet, "drop" by closing the connection, and echoes anything else.
+type faultHandler struct{}
+
+func (faultHandler) ServeRCON(ctx context.Context, req *Request) string {
+	return "unused"
+}
+
+func (faultHandler) ServeRCONResponse(ctx context.Context, req *Request) Response {
+	switch req.Command {
+	case "raw":
+		return Response{Raw: []byte{0, 0, 0, 0}}
+	case "drop":
+		return Response{Disconnect: true}
+	}
+	return Response{Output: req.Command}
+}
+
+func TestResponseHandler(t *testing.T) {
+	server := NewServer(PasswordAuthenticator(testPassword), faultHandler{})
+	defer server.Close()
+
+	cases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"echo", "echo", false},
+		{"raw", "", true},
+		{"drop", "", true},
+	}
+	for _, c := range cases {
+		serverCon, clientCon := net.Pipe()
+		served := make(chan error, 1)
+		go func() {
+			served <- server.ServeConn(context.Background(), serverCon)
+		}()
+		conn, err := NewRCONConnectionFromConn(context.Background(), clientCon, testPassword,
+			WithCommandTimeout(time.Second))
+		if err != nil {
+			t.Fatalf("Encountered error while connecting: %v", err)
+		}
+		got, err := conn.SendCommand(c.in)
+		if got != c.want || (err != nil) != c.wantErr {
+			t.Errorf("Command %q, expected %q and error %v, got %q and %v", c.in, c.want, c.wantErr, got, err)
+		}
+		conn.Close()
+		// The client gives up on a malformed response while the server may still be answering its ping
+		if err := <-served; err != nil && c.in != "raw" {
+			t.Errorf("Command %q, expected connection to end cleanly, got %v", c.in, err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+
+package rcon
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// A session is a single authenticated connection to the server, multiplexing the packets of concurrent commands over
+// it. Once a session fails it is never used again; an RCONConnection which reconnects does so with a new session.
+type session struct {
+	client *client
+
+	// writeMu serialises packet writes so that packets from concurrent commands are never interleaved on the wire
+	writeMu sync.Mutex
+
+	// mu guards the fields below
+	mu        sync.Mutex
+	idCounter int
+	pending   map[int]*call
+	err       error
+
+	// failed is closed, after err is set, once the session can no longer be used; readerDone is closed once the
+	// reader goroutine has exited
+	failed     chan struct{}
+	readerDone chan struct{}
+}
+
+// A call tracks the packet IDs used by a single in-flight command, and receives the response packets routed to those
+// IDs by the reader goroutine.
+type call struct {
+	ids     []int
+	packets chan Packet
+	// done is closed once the command no longer wants packets, so that the reader never blocks on it
+	done chan struct{}
+}
+
+// A Session is an authenticated connection as a Dialect sees it: a stream of packets shared by the commands sent
+// concurrently over the connection, each of which exchanges packets with the server in a Call of its own.
+type Session struct {
+	sess *session
+}
+
+// StartCall starts a call with n packet IDs not in use by any other call on the session. Callers must defer End on
+// the returned call.
+func (s *Session) StartCall(n int) (*Call, error) {
+	c, err := s.sess.startCall(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Call{sess: s.sess, c: c}, nil
+}
+
+// A Call is a single exchange of packets with the server over a Session, such as a command and its response. Packets
+// the server sends back with one of the IDs of the call are routed to it, and to no other.
+type Call struct {
+	sess *session
+	c    *call
+}
+
+// IDs returns the packet IDs of the call, in the order allocated. They are positive, and never 0, which is reserved
+// for authentication.
+func (c *Call) IDs() []int {
+	return append([]int(nil), c.c.ids...)
+}
+
+// Send writes packets to the server, all in one write, returning ctx.Err() if ctx is done first. Since a packet cut
+// off partway cannot be recovered from, any failure to write fails the connection.
+func (c *Call) Send(ctx context.Context, ps ...Packet) error {
+	return c.sess.send(ctx, ps...)
+}
+
+// Receive waits for the next packet sent back with one of the IDs of the call. It returns ctx.Err() if ctx is done
+// first, and the error the connection failed with if it fails.
+func (c *Call) Receive(ctx context.Context) (Packet, error) {
+	return c.sess.receive(ctx, c.c)
+}
+
+// End ends the call; packets arriving for it afterwards are discarded.
+func (c *Call) End() {
+	c.sess.endCall(c.c)
+}
+
+// newSession wraps an authenticated client, starting the reader goroutine which serves it.
+func newSession(client *client) *session {
+	s := &session{
+		client:     client,
+		pending:    make(map[int]*call),
+		failed:     make(chan struct{}),
+		readerDone: make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+// startCall allocates n packet IDs not currently in use and registers a call to receive the packets sent back with
+// them. Callers must defer endCall on the returned call.
+func (s *session) startCall(n int) (*call, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	c := &call{
+		ids:     make([]int, n),
+		packets: make(chan Packet, 4),
+		done:    make(chan struct{}),
+	}
+	for i := range c.ids {
+		id := s.counter()
+		c.ids[i] = id
+		s.pending[id] = c
+	}
+	return c, nil
+}
+
+// endCall unregisters a call started with startCall; packets arriving for it afterwards are discarded.
+func (s *session) endCall(c *call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range c.ids {
+		delete(s.pending, id)
+	}
+	close(c.done)
+}
+
+// counter returns the next free packet ID. IDs stay positive and within 32 bits, and skip 0, which is reserved for
+// authentication. s.mu must be held.
+func (s *session) counter() int {
+	for {
+		if s.idCounter >= math.MaxInt32 {
+			s.idCounter = 0
+		}
+		s.idCounter++
+		if _, ok := s.pending[s.idCounter]; !ok {
+			return s.idCounter
+		}
+	}
+}
+
+// send writes packets to the server, all in one write, abandoning the write once ctx is done. Since a packet cut off
+// partway cannot be recovered from, any failure to write fails the connection.
+func (s *session) send(ctx context.Context, ps ...Packet) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	select {
+	case <-s.failed:
+		return s.err
+	default:
+	}
+	err := s.client.withWriteContext(ctx, func() error {
+		return s.client.sendPackets(ps...)
+	})
+	if err != nil {
+		s.fail(fmt.Errorf("connection failed on write: %w", err))
+	}
+	return err
+}
+
+// receive waits for the next packet routed to c, returning early if ctx is done or the connection fails.
+func (s *session) receive(ctx context.Context, c *call) (Packet, error) {
+	select {
+	case p := <-c.packets:
+		return p, nil
+	case <-s.failed:
+		// Packets read before the failure are still delivered
+		select {
+		case p := <-c.packets:
+			return p, nil
+		default:
+		}
+		return Packet{}, s.err
+	case <-ctx.Done():
+		return Packet{}, ctx.Err()
+	}
+}
+
+// readLoop receives packets until the session fails, handing each one to the call its ID belongs to. Packets with
+// no matching call, such as late responses to cancelled commands, are discarded.
+func (s *session) readLoop() {
+	defer close(s.readerDone)
+	for {
+		p, err := s.client.receivePacket()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.mu.Lock()
+		c := s.pending[p.ID]
+		s.mu.Unlock()
+		if c == nil {
+			s.client.logger().Debug("discard packet with unknown id", "packet", p)
+			continue
+		}
+		select {
+		case c.packets <- p:
+		case <-c.done:
+		}
+	}
+}
+
+// fail marks the session as unusable with the given error, if it has not failed already, and closes the underlying
+// client so that the reader goroutine exits.
+func (s *session) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.failed)
+	if err != errClosed {
+		s.client.logger().Warn("connection failed", "error", err)
+	}
+	s.client.close()
+}
+
+// broken reports whether the session has failed for any reason other than being closed deliberately.
+func (s *session) broken() bool {
+	select {
+	case <-s.failed:
+		return s.err != errClosed
+	default:
+		return false
+	}
+}
+
+// close closes the session, waiting for the reader goroutine to exit. Commands in flight return with an error.
+func (s *session) close() {
+	s.fail(errClosed)
+	<-s.readerDone
+}
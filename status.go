@@ -0,0 +1,181 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the output of the SRCDS status command, as returned by Status or ParseStatus.
+type Status struct {
+	Hostname string
+	Version  string
+	// Map is the name of the current map, without the position some games append to it
+	Map        string
+	Humans     int
+	Bots       int
+	MaxPlayers int
+	Players    []StatusPlayer
+}
+
+// A StatusPlayer is one row of the player table in the output of the status command. Bots have the SteamID "BOT", and
+// no connected time, ping, loss or address.
+type StatusPlayer struct {
+	UserID    int
+	Name      string
+	SteamID   string
+	Connected time.Duration
+	Ping      int
+	Loss      int
+	// State is usually "active", or "spawning" or "connecting" while the player joins
+	State   string
+	Address string
+}
+
+// playerCountPattern matches the players line of the status command, capturing the number of humans, bots and
+// maximum players; the latter is followed by "/<reserved slots>" on some games.
+var playerCountPattern = regexp.MustCompile(`^(\d+) humans?, (\d+) bots? \((\d+)[/ ]`)
+
+// Status runs the status command and parses its output with ParseStatus. It only works with Source servers.
+func (conn *RCONConnection) Status(ctx context.Context) (*Status, error) {
+	output, err := conn.SendCommandContext(ctx, "status")
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatus(output)
+}
+
+// ParseStatus parses the output of the status command of a Source server, as returned by SendCommand. Lines other than
+// those it looks for are ignored, since they vary between games. It returns a non-nil error if output is not that of
+// the status command, or if a row of the player table cannot be parsed.
+func ParseStatus(output string) (*Status, error) {
+	status := &Status{}
+	var sawHostname, sawPlayers bool
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "#") {
+			// The player table has a header row, and on some games a closing "#end" row
+			fields := strings.Fields(strings.TrimPrefix(line, "#"))
+			if len(fields) == 0 || fields[0] == "userid" || fields[0] == "end" {
+				continue
+			}
+			player, err := parseStatusPlayer(line)
+			if err != nil {
+				return nil, err
+			}
+			status.Players = append(status.Players, player)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "hostname":
+			status.Hostname = value
+			sawHostname = true
+		case "version":
+			status.Version = value
+		case "map":
+			status.Map, _, _ = strings.Cut(value, " ")
+		case "players":
+			m := playerCountPattern.FindStringSubmatch(value)
+			if m == nil {
+				return nil, fmt.Errorf("cannot parse player counts %q", value)
+			}
+			status.Humans, _ = strconv.Atoi(m[1])
+			status.Bots, _ = strconv.Atoi(m[2])
+			status.MaxPlayers, _ = strconv.Atoi(m[3])
+			sawPlayers = true
+		}
+	}
+	if !sawHostname || !sawPlayers {
+		return nil, errors.New("output is not that of the status command")
+	}
+	return status, nil
+}
+
+// parseStatusPlayer parses a row of the player table, which reads
+//
+//	# <userid> "<name>" <uniqueid> <connected> <ping> <loss> <state> [rate] <adr>
+//
+// or, for bots, which have no connection,
+//
+//	# <userid> "<name>" BOT <state> [rate]
+//
+// Some games add a slot number after the user ID.
+func parseStatusPlayer(line string) (StatusPlayer, error) {
+	// Names may contain anything, but nothing else in the row is quoted
+	start, end := strings.Index(line, "\""), strings.LastIndex(line, "\"")
+	if start < 0 || start == end {
+		return StatusPlayer{}, fmt.Errorf("cannot parse player %q: no name", line)
+	}
+	before := strings.Fields(strings.TrimPrefix(line[:start], "#"))
+	after := strings.Fields(line[end+1:])
+	if len(before) == 0 || len(after) < 2 {
+		return StatusPlayer{}, fmt.Errorf("cannot parse player %q: too few fields", line)
+	}
+	userID, err := strconv.Atoi(before[0])
+	if err != nil {
+		return StatusPlayer{}, fmt.Errorf("cannot parse player %q: %w", line, err)
+	}
+	player := StatusPlayer{UserID: userID, Name: line[start+1 : end], SteamID: after[0]}
+	if player.SteamID == "BOT" {
+		player.State = after[1]
+		return player, nil
+	}
+
+	if len(after) < 6 {
+		return StatusPlayer{}, fmt.Errorf("cannot parse player %q: too few fields", line)
+	}
+	player.Connected, err = parseConnected(after[1])
+	if err == nil {
+		player.Ping, err = strconv.Atoi(after[2])
+	}
+	if err == nil {
+		player.Loss, err = strconv.Atoi(after[3])
+	}
+	if err != nil {
+		return StatusPlayer{}, fmt.Errorf("cannot parse player %q: %w", line, err)
+	}
+	player.State = after[4]
+	player.Address = after[len(after)-1]
+	return player, nil
+}
+
+// parseConnected parses a connected time as shown by the status command, which is "MM:SS" or "HH:MM:SS".
+func parseConnected(s string) (time.Duration, error) {
+	var d time.Duration
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid connected time %q", s)
+	}
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid connected time %q", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return d * time.Second, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const tf2Status = `hostname: Example TF2 | 24/7 Badlands
+version : 8622567/24 8622567 secure
+udp/ip  : 192.0.2.1:27015  (public ip: 192.0.2.1)
+steamid : [G:1:1234567] (85568392921234567)
+account : not logged in  (No account specified)
+map     : cp_badlands at: 0 x, 0 y, 0 z
+tags    : cp,increased_maxplayers
+sourcetv:  192.0.2.1:27020, delay 0.0s  (local: 192.0.2.1:27020)
+players : 2 humans, 1 bots (33 max)
+edicts  : 1121 used of 2048 max
+# userid name                uniqueid            connected ping loss state  adr
+#      2 "SourceTV"          BOT                                     active
+#      3 "alice"             [U:1:12345]         12:34       45    0 active 198.51.100.7:27005
+#      5 "bob "the" builder" [U:1:67890]          1:02:03    80    2 spawning 203.0.113.9:27005
+`
+
+const csgoStatus = `hostname: Example CS:GO Community
+version : 1.38.7.9/13879 1575/8853 secure  [G:1:3456789]
+udp/ip  : 0.0.0.0:27015  (public ip: 192.0.2.2)
+os      :  Linux
+type    :  community dedicated
+map     : de_dust2
+gotv[0]:  port 27020, delay 30.0s, rate 64.0
+players : 1 humans, 2 bots (20/0 max) (not hibernating)
+
+# userid name uniqueid connected ping loss state rate adr
+# 2 1 "GOTV" BOT active 64
+#  3 2 "carol" STEAM_1:0:12345 05:12 40 0 active 196608 198.51.100.8:27005
+# 4 "Bot Dave" BOT active 64
+#end
+`
+
+func TestParseStatus(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want *Status
+	}{
+		{"tf2", tf2Status, &Status{
+			Hostname: "Example TF2 | 24/7 Badlands", Version: "8622567/24 8622567 secure", Map: "cp_badlands",
+			Humans: 2, Bots: 1, MaxPlayers: 33,
+			Players: []StatusPlayer{
+				{UserID: 2, Name: "SourceTV", SteamID: "BOT", State: "active"},
+				{UserID: 3, Name: "alice", SteamID: "[U:1:12345]", Connected: 12*time.Minute + 34*time.Second,
+					Ping: 45, Loss: 0, State: "active", Address: "198.51.100.7:27005"},
+				{UserID: 5, Name: `bob "the" builder`, SteamID: "[U:1:67890]",
+					Connected: time.Hour + 2*time.Minute + 3*time.Second, Ping: 80, Loss: 2, State: "spawning",
+					Address: "203.0.113.9:27005"},
+			},
+		}},
+		{"csgo", csgoStatus, &Status{
+			Hostname: "Example CS:GO Community", Version: "1.38.7.9/13879 1575/8853 secure  [G:1:3456789]",
+			Map: "de_dust2", Humans: 1, Bots: 2, MaxPlayers: 20,
+			Players: []StatusPlayer{
+				{UserID: 2, Name: "GOTV", SteamID: "BOT", State: "active"},
+				{UserID: 3, Name: "carol", SteamID: "STEAM_1:0:12345", Connected: 5*time.Minute + 12*time.Second,
+					Ping: 40, Loss: 0, State: "active", Address: "198.51.100.8:27005"},
+				{UserID: 4, Name: "Bot Dave", SteamID: "BOT", State: "active"},
+			},
+		}},
+	}
+	for _, c := range cases {
+		got, err := ParseStatus(c.in)
+		if err != nil {
+			t.Errorf("Encountered error while parsing %v status: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Parsing %v status, expected %+v, got %+v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestParseStatusInvalid(t *testing.T) {
+	cases := []string{
+		"Unknown command \"status\"\n",
+		"hostname: Example\nplayers : lots\n",
+		"hostname: Example\nplayers : 1 humans, 0 bots (24 max)\n#  3 \"alice\" [U:1:1] soon 45 0 active 192.0.2.1:1\n",
+	}
+	for _, c := range cases {
+		if _, err := ParseStatus(c); err == nil {
+			t.Errorf("Parsing %q, expected error, got nil", c)
+		}
+	}
+}
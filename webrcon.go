@@ -0,0 +1,273 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vibeisveryo/rcon/internal/wire"
+)
+
+// webRCONMessageBuffer is how many unsolicited messages are held for the reader of Messages before more are dropped.
+const webRCONMessageBuffer = 64
+
+// A WebRCONMessage is a message sent by a server speaking WebRCON, either in response to a command or unprompted, as
+// with console output and chat.
+type WebRCONMessage struct {
+	// Identifier is that of the command the message responds to, or zero or negative for an unsolicited message
+	Identifier int `json:"Identifier"`
+	// Message is the text of the message
+	Message string `json:"Message"`
+	// Type classifies the message, for example "Generic", "Warning", "Error" or "Chat"
+	Type string `json:"Type"`
+	// Stacktrace accompanies some warnings and errors
+	Stacktrace string `json:"Stacktrace"`
+}
+
+// webRCONRequest is a command sent to a server speaking WebRCON.
+type webRCONRequest struct {
+	Identifier int    `json:"Identifier"`
+	Message    string `json:"Message"`
+	Name       string `json:"Name"`
+}
+
+// A WebRCONConnection connects to a remote server using WebRCON, the protocol used by Rust servers in place of Source
+// RCON. Commands and their responses are JSON messages exchanged over a WebSocket, correlated by an identifier; the
+// server also sends console output and chat unprompted, which are made available from Messages.
+//
+// A WebRCONConnection should be created with NewWebRCONConnection or NewWebRCONConnectionContext, and is safe for
+// concurrent use by multiple goroutines.
+type WebRCONConnection struct {
+	ws   *websocket.Conn
+	opts options
+
+	// writeMu serialises writes, which the WebSocket implementation does not allow concurrently
+	writeMu sync.Mutex
+
+	// mu guards the fields below
+	mu        sync.Mutex
+	idCounter int
+	pending   map[int]chan WebRCONMessage
+	err       error
+
+	// failed is closed, after err is set, once the connection can no longer be used
+	failed     chan struct{}
+	messages   chan WebRCONMessage
+	readerDone chan struct{}
+}
+
+// NewWebRCONConnection connects to the WebRCON server at the given host and port, authenticating with password. It
+// returns an AuthenticationFailure if the server rejects the password during the WebSocket handshake.
+func NewWebRCONConnection(host string, port int, password string, opts ...Option) (*WebRCONConnection, error) {
+	return NewWebRCONConnectionContext(context.Background(), host, port, password, opts...)
+}
+
+// NewWebRCONConnectionContext is like NewWebRCONConnection, but abandons connecting once ctx is done, in which case it
+// returns ctx.Err().
+func NewWebRCONConnectionContext(ctx context.Context, host string, port int, password string,
+	opts ...Option) (*WebRCONConnection, error) {
+	o, err := serverOptions(host, port, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The password is the path of the WebSocket URL
+	u := url.URL{Scheme: "ws", Host: net.JoinHostPort(host, strconv.Itoa(port)), Path: "/" + password}
+	dialer := websocket.Dialer{NetDialContext: o.dial}
+	handshakeCtx, cancel := o.commandContext(ctx)
+	defer cancel()
+	ws, resp, err := dialer.DialContext(handshakeCtx, u.String(), nil)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil &&
+			(resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return nil, &AuthenticationFailure{err}
+		}
+		return nil, dialError(ctx, err)
+	}
+
+	conn := &WebRCONConnection{
+		ws:         ws,
+		opts:       o,
+		pending:    make(map[int]chan WebRCONMessage),
+		failed:     make(chan struct{}),
+		messages:   make(chan WebRCONMessage, webRCONMessageBuffer),
+		readerDone: make(chan struct{}),
+	}
+	go conn.readLoop()
+	return conn, nil
+}
+
+// SendCommand takes a command string, sends it to the server, and returns the output as a string. It returns a non-nil
+// error on send or read failure.
+func (conn *WebRCONConnection) SendCommand(cmd string) (string, error) {
+	return conn.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext is like SendCommand, but gives up waiting on the server once ctx is done and returns ctx.Err().
+// The connection remains usable; a response arriving afterwards is discarded.
+func (conn *WebRCONConnection) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	return conn.opts.auditCommand(ctx, cmd, conn.sendCommand)
+}
+
+// sendCommand implements SendCommandContext.
+func (conn *WebRCONConnection) sendCommand(ctx context.Context, cmd string) (string, error) {
+	ctx, cancel := conn.opts.commandContext(ctx)
+	defer cancel()
+
+	id, response, err := conn.startCall()
+	if err != nil {
+		return "", err
+	}
+	defer conn.endCall(id)
+
+	err = conn.send(ctx, webRCONRequest{Identifier: id, Message: cmd, Name: "WebRcon"})
+	if err != nil {
+		return "", err
+	}
+	select {
+	case msg := <-response:
+		return msg.Message, nil
+	case <-conn.failed:
+		return "", conn.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Messages returns a channel delivering the messages sent by the server other than in response to a command, such as
+// console output and chat. The channel is closed when the connection fails or is closed. Messages are buffered, but
+// dropped if the buffer is full, so the channel should be drained promptly by anyone interested in them.
+func (conn *WebRCONConnection) Messages() <-chan WebRCONMessage {
+	return conn.messages
+}
+
+// Close closes the connection. Commands in flight on other goroutines return with an error.
+func (conn *WebRCONConnection) Close() {
+	conn.writeMu.Lock()
+	_ = conn.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	conn.writeMu.Unlock()
+	conn.fail(errClosed)
+	<-conn.readerDone
+}
+
+// startCall allocates an identifier not currently in use and registers a channel to receive the response sent back
+// with it. Callers must defer endCall with the returned identifier.
+func (conn *WebRCONConnection) startCall() (int, chan WebRCONMessage, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.err != nil {
+		return 0, nil, conn.err
+	}
+	// Identifiers stay positive, leaving zero and below to unsolicited messages
+	for {
+		if conn.idCounter >= math.MaxInt32 {
+			conn.idCounter = 0
+		}
+		conn.idCounter++
+		if _, ok := conn.pending[conn.idCounter]; !ok {
+			break
+		}
+	}
+	response := make(chan WebRCONMessage, 1)
+	conn.pending[conn.idCounter] = response
+	return conn.idCounter, response, nil
+}
+
+// endCall unregisters the identifier of a call started with startCall.
+func (conn *WebRCONConnection) endCall(id int) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	delete(conn.pending, id)
+}
+
+// send writes a request to the server, failing the connection if the write does not succeed.
+func (conn *WebRCONConnection) send(ctx context.Context, request webRCONRequest) error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	select {
+	case <-conn.failed:
+		return conn.err
+	default:
+	}
+	conn.opts.logger.Debug("send WebRCON message", "identifier", request.Identifier, "body", request.Message)
+	err := wire.Interruptible(ctx, conn.ws.SetWriteDeadline, func() error {
+		return conn.ws.WriteJSON(request)
+	})
+	if err != nil {
+		conn.fail(fmt.Errorf("connection failed on write: %w", err))
+	}
+	return err
+}
+
+// readLoop receives messages until the connection fails, handing responses to the commands awaiting them and
+// delivering everything else to Messages.
+func (conn *WebRCONConnection) readLoop() {
+	defer close(conn.readerDone)
+	defer close(conn.messages)
+	for {
+		var msg WebRCONMessage
+		err := conn.ws.ReadJSON(&msg)
+		if err != nil {
+			conn.fail(err)
+			return
+		}
+		conn.opts.logger.Debug("receive WebRCON message", "identifier", msg.Identifier, "type", msg.Type,
+			"body", msg.Message)
+		conn.mu.Lock()
+		response, ok := conn.pending[msg.Identifier]
+		if ok {
+			// Only the first message with an identifier is its response
+			delete(conn.pending, msg.Identifier)
+		}
+		conn.mu.Unlock()
+		if ok {
+			response <- msg
+			continue
+		}
+		select {
+		case conn.messages <- msg:
+		default:
+			conn.opts.logger.Warn("drop WebRCON message", "identifier", msg.Identifier, "body", msg.Message)
+		}
+	}
+}
+
+// fail marks the connection as unusable with the given error, if it has not failed already, and closes the WebSocket
+// so that the reader goroutine exits.
+func (conn *WebRCONConnection) fail(err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.err != nil {
+		return
+	}
+	conn.err = err
+	close(conn.failed)
+	_ = conn.ws.Close()
+}
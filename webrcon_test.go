@@ -0,0 +1,94 @@
+/*
+Copyright 2023 vorboyvo.
+
+This file is part of rcon.
+
+rcon is free software: you can redistribute it and/or modify it under the terms of the GNU General Public
+License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later
+version.
+
+rcon is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied
+warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with rcon. If not, see
+https://www.gnu.org/licenses.
+*/
+package rcon
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serveWebRCON accepts WebRCON clients authenticating with testPassword, echoing each command back after first
+// sending an unsolicited chat message.
+func serveWebRCON(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/"+testPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	upgrader := websocket.Upgrader{}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	for {
+		var request webRCONRequest
+		if ws.ReadJSON(&request) != nil {
+			return
+		}
+		_ = ws.WriteJSON(WebRCONMessage{Identifier: 0, Message: "chat", Type: "Chat"})
+		_ = ws.WriteJSON(WebRCONMessage{Identifier: request.Identifier, Message: request.Message, Type: "Generic"})
+	}
+}
+
+// newWebRCONServer starts serveWebRCON on a loopback port and returns the port.
+func newWebRCONServer(t *testing.T) int {
+	server := httptest.NewServer(http.HandlerFunc(serveWebRCON))
+	t.Cleanup(server.Close)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func TestWebRCONConnection(t *testing.T) {
+	port := newWebRCONServer(t)
+	conn, err := NewWebRCONConnection("127.0.0.1", port, testPassword, WithCommandTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Encountered error while connecting: %v", err)
+	}
+	defer conn.Close()
+
+	for _, cmd := range []string{"status", "say hello", ""} {
+		got, err := conn.SendCommand(cmd)
+		if err != nil {
+			t.Errorf("Encountered error while sending command %q: %v", cmd, err)
+		}
+		if got != cmd {
+			t.Errorf("Command %q, expected response %q, got %q", cmd, cmd, got)
+		}
+	}
+	select {
+	case msg := <-conn.Messages():
+		if msg.Message != "chat" || msg.Type != "Chat" {
+			t.Errorf("Expected chat message, got %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for unsolicited message")
+	}
+}
+
+func TestWebRCONWrongPassword(t *testing.T) {
+	port := newWebRCONServer(t)
+	_, err := NewWebRCONConnection("127.0.0.1", port, "wrong")
+	if _, ok := err.(*AuthenticationFailure); !ok {
+		t.Errorf("Expected authentication failure, got %v", err)
+	}
+}